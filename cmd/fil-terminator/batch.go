@@ -4,34 +4,85 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
-	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
-	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/strahe/fil-terminator/pkg/utils"
 	"github.com/urfave/cli/v2"
 )
 
 var batchCmd = &cli.Command{
 	Name:        "batch",
-	Usage:       "Batch calculate termination fees from CSV file",
-	Description: "Read miners and epochs from CSV file and calculate termination fees for all sectors",
+	Usage:       "Batch calculate termination fees from CSV or job file",
+	Description: "Read miners and epochs from a CSV file, or a JSON/YAML job file, and calculate termination fees for all sectors",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:     "input",
 			Aliases:  []string{"i"},
-			Usage:    "Input CSV file path (format: minerid,epoch)",
+			Usage:    "Input CSV file (columns: minerid, epoch or time, optional sectors and label), or job file ending in .json, .yaml or .yml",
 			Required: true,
 		},
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output CSV file path (optional, print to terminal if not specified)",
+			Usage:   "Output file path, CSV unless the global --output-format is set (optional, print to terminal if not specified)",
+		},
+		modelFlag,
+		modelParamsFlag,
+		modelFileFlag,
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
+		&cli.IntFlag{
+			Name:    "concurrency",
+			Aliases: []string{"j"},
+			Usage:   "Number of miners processed in parallel",
+			Value:   4,
+		},
+		&cli.Float64Flag{
+			Name:  "rps",
+			Usage: "Maximum requests per second sent to the Lotus API, 0 for no limit",
+		},
+		&cli.StringFlag{
+			Name:  "sectors-output",
+			Usage: "Also write one row per miner, epoch and sector to this file",
+		},
+		&cli.StringFlag{
+			Name:  "sectors-format",
+			Usage: "Format of --sectors-output (csv, parquet)",
+			Value: SectorsFormatCSV,
+		},
+		&cli.StringFlag{
+			Name:  "group-by",
+			Usage: "Also report totals per group of miners (owner, worker, beneficiary, label)",
+		},
+		&cli.StringFlag{
+			Name:  "group-output",
+			Usage: "Write the --group-by report to this file in the output format (CSV for text) instead of printing it",
+		},
+		&cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Stop at the first failed miner instead of processing the remaining ones",
+		},
+		&cli.StringFlag{
+			Name:  "error-report",
+			Usage: "Write the failed miners with their error category to this JSON file",
+		},
+		&cli.StringFlag{
+			Name:  "checkpoint",
+			Usage: "Journal file that records every finished miner, used by --resume",
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "Reuse successful results from --checkpoint and only calculate failed or missing miners",
 		},
 
 		&cli.BoolFlag{
@@ -50,72 +101,305 @@ var batchCmd = &cli.Command{
 type MinerTask struct {
 	MinerID string
 	Epoch   abi.ChainEpoch
+	Sectors string // sector selection in ParseSectorNumbers syntax, empty means all sectors
+	Label   string // carried through to the output
+	Model   string // key of the projection model, see modelKey
 }
 
 type MinerResult struct {
-	MinerID        string
-	Epoch          abi.ChainEpoch
-	TotalSectors   int
-	ActiveSectors  int
-	ExpiredSectors int
-	TotalFee       big.Int
-	Status         string
-	Error          string
+	MinerID            string         `json:"miner_id"`
+	Epoch              abi.ChainEpoch `json:"epoch"`
+	TotalSectors       int            `json:"total_sectors"`
+	ActiveSectors      int            `json:"active_sectors"`
+	ExpiredSectors     int            `json:"expired_sectors"`
+	TotalFee           big.Int        `json:"total_fee"`    // attoFIL
+	TotalPledge        big.Int        `json:"total_pledge"` // attoFIL
+	BindingTerms       string         `json:"binding_terms"`
+	Formula            string         `json:"formula"`
+	Projection         string         `json:"projection"`
+	Status             string         `json:"status"`
+	Error              string         `json:"error"`
+	Err                error          `json:"-"`        // typed cause of Error, see utils.CalculationError
+	Duration           time.Duration  `json:"duration"` // wall-clock time spent on the miner
+	Retries            int            `json:"retries"`  // chain reads retried for the miner
+	Sectors            string         `json:"sectors,omitempty"`
+	Label              string         `json:"label,omitempty"`
+	Owner              string         `json:"owner,omitempty"`
+	Worker             string         `json:"worker,omitempty"`
+	Beneficiary        string         `json:"beneficiary,omitempty"`
+	SectorStatuses     string         `json:"sector_statuses,omitempty"` // non-expired sectors by status, e.g. "active=10;faulty=2"
+	TerminatedSectors  int            `json:"terminated_sectors,omitempty"`
+	ApproximateSectors int            `json:"approximate_sectors,omitempty"` // see utils.SectorResult.Approximate
 }
 
 func batchCalculate(c *cli.Context) error {
-	api, closer, err := lcli.GetFullNodeAPIV1(c)
+	format, err := getOutputFormat(c)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Lotus node: %w", err)
+		return invalidInput(err)
+	}
+
+	// Settings of a job file apply unless the flag is given on the command line
+	var job *BatchJob
+	outputFile := c.String("output")
+	sectorsOutputFile := c.String("sectors-output")
+	sectorsFormat := c.String("sectors-format")
+	groupBy := c.String("group-by")
+	groupOutputFile := c.String("group-output")
+	errorReportFile := c.String("error-report")
+	if isJobFile(c.String("input")) {
+		job, err = loadBatchJob(c.String("input"))
+		if err != nil {
+			return invalidInput(fmt.Errorf("failed to read job file: %w", err))
+		}
+		if job.OutputFormat != "" && !c.IsSet("output-format") {
+			format = job.OutputFormat
+		}
+		if job.Output != "" && !c.IsSet("output") {
+			outputFile = job.path(job.Output)
+		}
+		if job.SectorsOutput != "" && !c.IsSet("sectors-output") {
+			sectorsOutputFile = job.path(job.SectorsOutput)
+		}
+		if job.SectorsFormat != "" && !c.IsSet("sectors-format") {
+			sectorsFormat = job.SectorsFormat
+		}
+		if job.GroupBy != "" && !c.IsSet("group-by") {
+			groupBy = job.GroupBy
+		}
+		if job.GroupOutput != "" && !c.IsSet("group-output") {
+			groupOutputFile = job.path(job.GroupOutput)
+		}
+		if job.ErrorReport != "" && !c.IsSet("error-report") {
+			errorReportFile = job.path(job.ErrorReport)
+		}
+	}
+
+	if groupBy != "" {
+		if groupBy, err = parseGroupBy(groupBy); err != nil {
+			return invalidInput(err)
+		}
+	} else if groupOutputFile != "" {
+		return invalidInput(fmt.Errorf("--group-output requires --group-by"))
+	}
+	if c.Bool("resume") && c.String("checkpoint") == "" {
+		return invalidInput(fmt.Errorf("--resume requires --checkpoint"))
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
 	}
 	defer closer()
 
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
 
-	// Read CSV file
-	tasks, err := readCSVFile(c.String("input"))
+	model, err := getNetworkModel(c)
 	if err != nil {
-		return fmt.Errorf("failed to read CSV file: %w", err)
+		return invalidInput(fmt.Errorf("invalid projection model: %w", err))
 	}
 
-	if len(tasks) == 0 {
-		return fmt.Errorf("no tasks found in CSV file")
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current height: %w", err)
 	}
 
-	fmt.Printf("Processing %d miners...\n", len(tasks))
+	var tasks []MinerTask
+	models := map[string]utils.NetworkModel{modelKey(model): model}
+	if job != nil {
+		tasks, models, err = job.BuildTasks(utils.GenesisTime(head), model)
+		if err != nil {
+			return invalidInput(fmt.Errorf("invalid job file: %w", err))
+		}
+	} else {
+		tasks, err = readCSVFile(c.String("input"), utils.GenesisTime(head))
+		if err != nil {
+			return invalidInput(fmt.Errorf("failed to read CSV file: %w", err))
+		}
+		if len(tasks) == 0 {
+			return invalidInput(fmt.Errorf("no tasks found in CSV file"))
+		}
+		for i := range tasks {
+			tasks[i].Model = modelKey(model)
+		}
+	}
 
-	// Process each miner
+	var journal *checkpoint
+	if c.String("checkpoint") != "" {
+		journal, err = openCheckpoint(c.String("checkpoint"), c.Bool("resume"))
+		if err != nil {
+			return fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+		defer journal.Close()
+	}
+
+	// Results are written as they complete, so an interrupted run keeps its output. Text
+	// results are printed as a table at the end, or written as CSV to --output.
+	info := io.Writer(os.Stdout)
+	var output resultWriter
+	if format != FormatText || outputFile != "" {
+		if format == FormatText {
+			format = FormatCSV
+		}
+		if outputFile == "" {
+			// Keep stdout clean for the results
+			info = os.Stderr
+		}
+		output, err = newResultWriter(format, outputFile)
+		if err != nil {
+			return fmt.Errorf("failed to create output: %w", err)
+		}
+		defer output.Close()
+	}
+
+	var sectorOutput sectorWriter
+	if sectorsOutputFile != "" {
+		sectorOutput, err = newSectorWriter(sectorsFormat, sectorsOutputFile)
+		if err != nil {
+			return fmt.Errorf("failed to create sectors output: %w", err)
+		}
+		defer sectorOutput.Close()
+	}
+
+	fmt.Fprintf(info, "Processing %d miners...\n", len(tasks))
+
+	snapshots := newSnapshotCache(api, head.Height(), tasks)
+
+	// Process miners in parallel, results are collected in input order
 	results := make([]MinerResult, 0, len(tasks))
 	totalFee := big.Zero()
+	reused := 0
+	start := time.Now()
+
+	process := func(ctx context.Context, task MinerTask) taskOutcome {
+		defer snapshots.release(task)
+		if journal != nil {
+			if result, ok := journal.Succeeded(task); ok {
+				out := taskOutcome{result: result, reused: true}
+				// The journal only keeps miner results, sectors are recomputed from the chain
+				if sectorOutput != nil {
+					sectorResult, calcResult := calculateMinerFee(ctx, snapshots, task, models[task.Model])
+					if sectorResult.Error != "" {
+						fmt.Fprintf(os.Stderr, "failed to recompute sectors of reused miner %s: %s\n", task.MinerID, sectorResult.Error)
+					} else {
+						out.calcResult, out.sectors = calcResult, true
+					}
+				}
+				return out
+			}
+		}
 
-	for i, task := range tasks {
-		if c.Bool("verbose") {
-			fmt.Printf("[%d/%d] Processing miner %s at epoch %d...\n", i+1, len(tasks), task.MinerID, task.Epoch)
+		taskStart := time.Now()
+		ctx, stats := utils.WithRetryStats(ctx)
+		result, calcResult := calculateMinerFee(ctx, snapshots, task, models[task.Model])
+		result.Duration = time.Since(taskStart)
+		result.Retries = stats.Retries()
+
+		// Results of a canceled run are not final, leave them to the next resume
+		if journal != nil && ctx.Err() == nil {
+			if err := journal.Record(task, result); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write checkpoint for miner %s: %v\n", task.MinerID, err)
+			}
 		}
+		return taskOutcome{result: result, calcResult: calcResult, sectors: result.Error == ""}
+	}
 
-		result := calculateMinerFee(ctx, api, task)
+	var writeErr error
+	var strictFailure *MinerResult
+	processTasks(ctx, tasks, c.Int("concurrency"), process, func(out taskOutcome) {
+		// Tasks after the failure that stopped a strict run were canceled, drop them
+		if strictFailure != nil {
+			return
+		}
+		result := out.result
 		results = append(results, result)
+		if result.Error != "" && c.Bool("strict") {
+			strictFailure = &result
+			cancel()
+		}
+
+		if output != nil && writeErr == nil {
+			if writeErr = output.Write(result); writeErr != nil {
+				cancel()
+			}
+		}
+		if sectorOutput != nil && writeErr == nil && out.sectors {
+			if err := sectorOutput.Write(out.calcResult, result.Label); err != nil {
+				writeErr = fmt.Errorf("sectors: %w", err)
+				cancel()
+			}
+		}
+
+		if out.reused {
+			reused++
+		}
+		if c.Bool("verbose") {
+			if out.reused {
+				fmt.Fprintf(info, "[%d/%d] Reused checkpoint result of miner %s at epoch %d\n", len(results), len(tasks), result.MinerID, result.Epoch)
+			} else {
+				fmt.Fprintf(info, "[%d/%d] Processed miner %s at epoch %d in %s (%d retries)\n", len(results), len(tasks), result.MinerID, result.Epoch, formatDuration(result.Duration), result.Retries)
+				if result.Error == "" {
+					printSectorDetails(info, out.calcResult)
+				}
+			}
+		}
 
 		if result.Error == "" {
 			totalFee = big.Add(totalFee, result.TotalFee)
 		}
+	})
+	if writeErr != nil {
+		return fmt.Errorf("failed to write output: %w", writeErr)
+	}
+	if err := ctx.Err(); err != nil && strictFailure == nil {
+		return err
+	}
+	elapsed := time.Since(start)
+
+	if errorReportFile != "" {
+		if err := writeErrorReport(errorReportFile, newErrorReport(len(tasks), results, strictFailure != nil)); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
 	}
 
 	// Output results
-	if c.String("output") != "" {
-		err = writeCSVResults(c.String("output"), results)
-		if err != nil {
-			return fmt.Errorf("failed to write output CSV: %w", err)
+	if sectorOutput != nil {
+		if err := sectorOutput.Close(); err != nil {
+			return fmt.Errorf("failed to write sectors output: %w", err)
+		}
+		fmt.Fprintf(info, "Sector results written to %s\n", sectorsOutputFile)
+	}
+	if output != nil {
+		if err := output.Close(); err != nil {
+			return fmt.Errorf("failed to write output: %w", err)
+		}
+		if outputFile != "" {
+			fmt.Fprintf(info, "Results written to %s\n", outputFile)
 		}
-		fmt.Printf("Results written to %s\n", c.String("output"))
 	} else {
 		printResults(results)
 	}
 
+	if groupBy != "" {
+		groups := groupResults(results, groupBy)
+		for _, g := range groups {
+			if g.Duplicates > 0 {
+				fmt.Fprintf(os.Stderr, "Warning: group %s at epoch %d lists %d results again for the same miner and sectors, only the first is counted\n", g.Key, g.Epoch, g.Duplicates)
+			}
+		}
+		if groupOutputFile != "" {
+			if err := writeGroups(format, groupOutputFile, groupBy, groups); err != nil {
+				return fmt.Errorf("failed to write group output: %w", err)
+			}
+			fmt.Fprintf(info, "Group results written to %s\n", groupOutputFile)
+		} else {
+			printGroups(info, groupBy, groups)
+		}
+	}
+
 	// Print summary
-	fmt.Printf("\n=== Summary ===\n")
-	fmt.Printf("Total miners processed: %d\n", len(results))
+	fmt.Fprintf(info, "\n=== Summary ===\n")
+	fmt.Fprintf(info, "Total miners processed: %d\n", len(results))
 
 	successCount := 0
 	for _, r := range results {
@@ -124,14 +408,52 @@ func batchCalculate(c *cli.Context) error {
 		}
 	}
 
-	fmt.Printf("Successful calculations: %d\n", successCount)
-	fmt.Printf("Failed calculations: %d\n", len(results)-successCount)
-	fmt.Printf("Total termination fee: %s\n", types.FIL(totalFee))
+	fmt.Fprintf(info, "Successful calculations: %d\n", successCount)
+	fmt.Fprintf(info, "Failed calculations: %d\n", len(results)-successCount)
+	if strictFailure != nil {
+		fmt.Fprintf(info, "Not processed (strict mode): %d\n", len(tasks)-len(results))
+	}
+	if journal != nil {
+		fmt.Fprintf(info, "Reused from checkpoint: %d\n", reused)
+	}
+	if errorReportFile != "" {
+		fmt.Fprintf(info, "Error report written to %s\n", errorReportFile)
+	}
+	fmt.Fprintf(info, "Total termination fee: %s\n", types.FIL(totalFee))
+	fmt.Fprintf(info, "Total time: %s\n", formatDuration(elapsed))
+
+	totalRetries := 0
+	for _, r := range results {
+		totalRetries += r.Retries
+	}
+	fmt.Fprintf(info, "Total retries: %d\n", totalRetries)
+
+	fmt.Fprintf(info, "Time and retries per miner:\n")
+	for _, r := range results {
+		fmt.Fprintf(info, "  %-12s %-10d %-12s %d\n", r.MinerID, r.Epoch, formatDuration(r.Duration), r.Retries)
+	}
 
-	return nil
+	return failureExit(len(tasks), len(results), len(results)-successCount, strictFailure)
 }
 
-func readCSVFile(filename string) ([]MinerTask, error) {
+// Input columns are matched by header name and other columns are ignored, files without a
+// header are read as minerid,epoch
+var csvColumns = map[string]string{
+	"minerid":  "miner",
+	"miner":    "miner",
+	"miner_id": "miner",
+	"epoch":    "epoch",
+	"time":     "time",
+	"date":     "time",
+	"datetime": "time",
+	"sectors":  "sectors",
+	"sector":   "sectors",
+	"label":    "label",
+	"tag":      "label",
+}
+
+// readCSVFile reads the batch tasks, times are converted to epochs with genesis
+func readCSVFile(filename string, genesis time.Time) ([]MinerTask, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -143,107 +465,351 @@ func readCSVFile(filename string) ([]MinerTask, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns, err := csvHeader(records[0])
+	if err != nil {
+		return nil, err
+	}
+	first := 1
+	if columns == nil {
+		columns = map[string]int{"miner": 0, "epoch": 1}
+		first = 0
+	}
 
 	tasks := make([]MinerTask, 0, len(records))
-	for i, record := range records {
-		// Skip header row if it exists
-		if i == 0 && (strings.ToLower(record[0]) == "minerid" || strings.ToLower(record[0]) == "miner") {
-			continue
+	for i := first; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 2 {
+			return nil, fmt.Errorf("invalid CSV format at line %d: expected at least 2 columns", i+1)
 		}
 
-		if len(record) < 2 {
-			return nil, fmt.Errorf("invalid CSV format at line %d: expected 2 columns", i+1)
+		field := func(name string) string {
+			if col, ok := columns[name]; ok {
+				return strings.TrimSpace(record[col])
+			}
+			return ""
 		}
 
-		minerID := strings.TrimSpace(record[0])
-		epochStr := strings.TrimSpace(record[1])
+		task := MinerTask{
+			MinerID: field("miner"),
+			Sectors: field("sectors"),
+			Label:   field("label"),
+		}
 
-		epoch, err := strconv.ParseInt(epochStr, 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid epoch at line %d: %s", i+1, epochStr)
+		epochStr, timeStr := field("epoch"), field("time")
+		switch {
+		case epochStr != "" && timeStr != "":
+			return nil, fmt.Errorf("line %d sets both epoch and time", i+1)
+		case timeStr != "":
+			t, err := utils.ParseTime(timeStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time at line %d: %w", i+1, err)
+			}
+			task.Epoch = utils.TimeToEpoch(t, genesis)
+		default:
+			epoch, err := strconv.ParseInt(epochStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid epoch at line %d: %s", i+1, epochStr)
+			}
+			task.Epoch = abi.ChainEpoch(epoch)
 		}
 
-		tasks = append(tasks, MinerTask{
-			MinerID: minerID,
-			Epoch:   abi.ChainEpoch(epoch),
-		})
+		if task.Sectors != "" {
+			if _, err := utils.ParseSectorNumbers(task.Sectors); err != nil {
+				return nil, fmt.Errorf("invalid sectors at line %d: %w", i+1, err)
+			}
+		}
+
+		tasks = append(tasks, task)
 	}
 
 	return tasks, nil
 }
 
-func calculateMinerFee(ctx context.Context, api api.FullNode, task MinerTask) MinerResult {
-	// Prepare calculation request
-	req := utils.CalculationRequest{
-		MinerID:       task.MinerID,
-		TargetEpoch:   task.Epoch,
-		SectorNumbers: []abi.SectorNumber{}, // empty means all sectors
+// csvHeader maps known column names to indexes, nil means the record is not a header
+func csvHeader(record []string) (map[string]int, error) {
+	first := strings.ToLower(strings.TrimSpace(record[0]))
+	if csvColumns[first] != "miner" {
+		return nil, nil
 	}
 
+	columns := make(map[string]int)
+	for i, name := range record {
+		column, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			continue
+		}
+		if _, dup := columns[column]; dup {
+			return nil, fmt.Errorf("duplicate CSV column: %s", name)
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["epoch"]; !ok {
+		if _, ok := columns["time"]; !ok {
+			return nil, fmt.Errorf("CSV header needs an epoch or time column")
+		}
+	}
+	return columns, nil
+}
+
+func calculateMinerFee(ctx context.Context, snapshots *snapshotCache, task MinerTask, model utils.NetworkModel) (MinerResult, utils.CalculationResult) {
 	// Calculate termination fees
-	calcResult := utils.CalculateTerminationFee(ctx, api, req)
+	snap, err := snapshots.get(ctx, task)
+	if err == nil {
+		var calcResult utils.CalculationResult
+		if calcResult, err = snap.Evaluate(task.Epoch, model); err == nil {
+			result := newMinerResult(calcResult)
+			result.Sectors = task.Sectors
+			result.Label = task.Label
+			result.Owner = snap.Owner().String()
+			result.Worker = snap.Worker().String()
+			result.Beneficiary = snap.Beneficiary().String()
+			return result, calcResult
+		}
+	}
+
+	return MinerResult{
+		MinerID:     task.MinerID,
+		Epoch:       task.Epoch,
+		TotalFee:    big.Zero(),
+		TotalPledge: big.Zero(),
+		Status:      "failed",
+		Error:       err.Error(),
+		Err:         err,
+		Sectors:     task.Sectors,
+		Label:       task.Label,
+	}, utils.CalculationResult{}
+}
 
-	// Convert to MinerResult
+// newMinerResult converts a successful calculation to a MinerResult
+func newMinerResult(calcResult utils.CalculationResult) MinerResult {
 	result := MinerResult{
 		MinerID:        calcResult.MinerID,
 		Epoch:          calcResult.TargetEpoch,
+		Status:         "success",
 		TotalSectors:   calcResult.TotalSectors,
 		ActiveSectors:  calcResult.ActiveSectors,
 		ExpiredSectors: calcResult.ExpiredSectors,
 		TotalFee:       calcResult.TotalFee,
-		Error:          calcResult.Error,
+		TotalPledge:    calcResult.TotalPledge,
+		BindingTerms:   formatBindingTerms(calcResult.SectorResults),
+		Formula:        calcResult.Formula,
+
+		SectorStatuses:     formatStatusTotals(calcResult.StatusTotals),
+		TerminatedSectors:  calcResult.TerminatedSectors,
+		ApproximateSectors: calcResult.ApproximateSectors,
 	}
 
-	if calcResult.Error == "" {
-		result.Status = "success"
-	} else {
-		result.Status = "failed"
+	if calcResult.Projection != nil {
+		result.Projection = calcResult.Projection.String()
 	}
 
 	return result
 }
 
-func writeCSVResults(filename string, results []MinerResult) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// formatBindingTerms counts active sectors by the term that set their fee, e.g. "age=3;pledge-cap=10"
+func formatBindingTerms(sectors []utils.SectorResult) string {
+	counts := make(map[string]int)
+	for _, s := range sectors {
+		if !s.IsExpired {
+			counts[s.BindingTerm]++
+		}
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	parts := make([]string, 0, len(counts))
+	for _, term := range []string{utils.TermAge, utils.TermPledgeCap, utils.TermMinPledge, utils.TermFaultFee, utils.TermRewardCap, utils.TermLowerBound} {
+		if counts[term] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", term, counts[term]))
+		}
+	}
+	return strings.Join(parts, ";")
+}
 
-	// Write header
-	header := []string{"MinerID", "Epoch", "Status", "TotalSectors", "ActiveSectors", "ExpiredSectors", "TotalFee(FIL)", "Error"}
-	if err := writer.Write(header); err != nil {
-		return err
+// formatStatusTotals counts non-expired sectors by status, e.g. "active=10;faulty=2"
+func formatStatusTotals(totals []utils.StatusTotal) string {
+	parts := make([]string, 0, len(totals))
+	for _, st := range totals {
+		parts = append(parts, fmt.Sprintf("%s=%d", st.Status, st.Sectors))
 	}
+	return strings.Join(parts, ";")
+}
 
-	// Write results
-	for _, result := range results {
-		record := []string{
-			result.MinerID,
-			fmt.Sprintf("%d", result.Epoch),
-			result.Status,
-			fmt.Sprintf("%d", result.TotalSectors),
-			fmt.Sprintf("%d", result.ActiveSectors),
-			fmt.Sprintf("%d", result.ExpiredSectors),
-			types.FIL(result.TotalFee).String(),
-			result.Error,
+// taskOutcome is the result of one task processed by processTasks
+type taskOutcome struct {
+	index      int
+	result     MinerResult
+	calcResult utils.CalculationResult
+	reused     bool // result was taken from the checkpoint
+	sectors    bool // calcResult holds the sectors of result
+}
+
+// processTasks runs process on up to workers tasks at a time and passes the outcomes to emit
+// in input order. emit is only called from the calling goroutine. Tasks that have not started
+// when ctx is cancelled are skipped.
+func processTasks(ctx context.Context, tasks []MinerTask, workers int, process func(context.Context, MinerTask) taskOutcome, emit func(taskOutcome)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(tasks) {
+		workers = len(tasks)
+	}
+
+	indexes := make(chan int)
+	outcomes := make(chan taskOutcome)
+
+	go func() {
+		defer close(indexes)
+		for i := range tasks {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// The feeder may hand out one more task while ctx is being cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				out := process(ctx, tasks[i])
+				out.index = i
+				outcomes <- out
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// Hold back outcomes that finish early until all tasks before them are emitted
+	pending := make(map[int]taskOutcome)
+	next := 0
+	for out := range outcomes {
+		pending[out.index] = out
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(ready)
+			next++
+		}
+	}
+}
+
+// formatDuration rounds d for display
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// snapshotCache reuses snapshots between tasks that resolve to the same miner state:
+// repeated historical epochs of a miner, or any future epochs of a miner. A snapshot is
+// dropped once the last task using it is released, so only the snapshots of tasks in
+// flight stay in memory. It is safe for concurrent use, tasks asking for a snapshot that
+// is being loaded wait for that load.
+type snapshotCache struct {
+	api  utils.ChainReader
+	head abi.ChainEpoch
+
+	lk        sync.Mutex
+	snapshots map[string]*snapshotEntry
+	refs      map[string]int // tasks not yet released per key
+}
+
+// snapshotEntry is a cached snapshot, done is closed once snap or err is set
+type snapshotEntry struct {
+	done chan struct{}
+	snap *utils.Snapshot
+	err  error
+}
+
+func newSnapshotCache(api utils.ChainReader, head abi.ChainEpoch, tasks []MinerTask) *snapshotCache {
+	c := &snapshotCache{
+		api:       api,
+		head:      head,
+		snapshots: make(map[string]*snapshotEntry),
+		refs:      make(map[string]int),
+	}
+	for _, task := range tasks {
+		key, _ := c.key(task)
+		c.refs[key]++
+	}
+	return c
+}
+
+// key identifies the miner state a task is evaluated on
+func (c *snapshotCache) key(task MinerTask) (string, abi.ChainEpoch) {
+	loadEpoch := task.Epoch
+	if task.Epoch == 0 || task.Epoch > c.head {
+		loadEpoch = c.head
+	}
+	return fmt.Sprintf("%s@%d/%s", task.MinerID, loadEpoch, task.Sectors), loadEpoch
+}
+
+func (c *snapshotCache) get(ctx context.Context, task MinerTask) (*utils.Snapshot, error) {
+	key, loadEpoch := c.key(task)
+
+	// Selections were validated when the input was read
+	sectorNumbers, _ := utils.ParseSectorNumbers(task.Sectors)
+
+	c.lk.Lock()
+	if entry, ok := c.snapshots[key]; ok {
+		c.lk.Unlock()
+		select {
+		case <-entry.done:
+			return entry.snap, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		if err := writer.Write(record); err != nil {
-			return err
+	}
+	entry := &snapshotEntry{done: make(chan struct{})}
+	c.snapshots[key] = entry
+	c.lk.Unlock()
+
+	entry.snap, entry.err = utils.LoadSnapshot(ctx, c.api, task.MinerID, loadEpoch, sectorNumbers)
+	if entry.err != nil {
+		// Only tasks already waiting share the failure, later tasks load again
+		c.lk.Lock()
+		if c.snapshots[key] == entry {
+			delete(c.snapshots, key)
 		}
+		c.lk.Unlock()
 	}
+	close(entry.done)
+	return entry.snap, entry.err
+}
 
-	return nil
+// release marks a task as done with its snapshot, every task is released once whether it
+// used the cache or not. The snapshot is dropped after the last task using it.
+func (c *snapshotCache) release(task MinerTask) {
+	key, _ := c.key(task)
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.refs[key]--; c.refs[key] <= 0 {
+		delete(c.refs, key)
+		delete(c.snapshots, key)
+	}
 }
 
 func printResults(results []MinerResult) {
 	fmt.Printf("\n=== Results ===\n")
-	fmt.Printf("%-12s %-10s %-8s %-6s %-6s %-8s %-15s %s\n",
-		"MinerID", "Epoch", "Status", "Total", "Active", "Expired", "Fee(FIL)", "Error")
-	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("%-12s %-10s %-8s %-6s %-6s %-8s %-15s %-12s %s\n",
+		"MinerID", "Epoch", "Status", "Total", "Active", "Expired", "Fee(FIL)", "Label", "Error")
+	fmt.Println(strings.Repeat("-", 93))
 
 	for _, result := range results {
 		errorMsg := result.Error
@@ -251,7 +817,7 @@ func printResults(results []MinerResult) {
 			errorMsg = errorMsg[:20] + "..."
 		}
 
-		fmt.Printf("%-12s %-10d %-8s %-6d %-6d %-8d %-15s %s\n",
+		fmt.Printf("%-12s %-10d %-8s %-6d %-6d %-8d %-15s %-12s %s\n",
 			result.MinerID,
 			result.Epoch,
 			result.Status,
@@ -259,6 +825,7 @@ func printResults(results []MinerResult) {
 			result.ActiveSectors,
 			result.ExpiredSectors,
 			types.FIL(result.TotalFee).String(),
+			result.Label,
 			errorMsg,
 		)
 	}
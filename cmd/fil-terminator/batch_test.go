@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+const testMiner = "f01234"
+
+// fixtureReader replays the recorded chain reads of miner f01234, by default at its chain
+// head. Further fixtures are merged in, e.g. "historical" for epoch 900000.
+func fixtureReader(t *testing.T, names ...string) utils.ChainReader {
+	t.Helper()
+	merged := utils.NewFixture()
+	for _, name := range append([]string{"current"}, names...) {
+		f, err := utils.LoadFixture(filepath.Join("..", "..", "pkg", "utils", "testdata", "fixtures", name+".json"))
+		require.NoError(t, err)
+		maps.Copy(merged.Calls, f.Calls)
+		maps.Copy(merged.Blocks, f.Blocks)
+	}
+	return utils.NewReplayReader(merged)
+}
+
+// runApp runs the command line with chain reads served by api
+func runApp(t *testing.T, ctx context.Context, api utils.ChainReader, args ...string) error {
+	t.Helper()
+	getChainReader = func(*cli.Context) (utils.ChainReader, func(), error) {
+		return api, func() {}, nil
+	}
+	t.Cleanup(func() { getChainReader = openChainReader })
+
+	app := &cli.App{
+		Name:           "fil-terminator",
+		Flags:          []cli.Flag{outputFormatFlag},
+		Commands:       []*cli.Command{batchCmd, timelineCmd, terminateCmd},
+		Writer:         io.Discard,
+		ExitErrHandler: func(*cli.Context, error) {},
+	}
+	return app.RunContext(ctx, append([]string{"fil-terminator"}, args...))
+}
+
+// countingReader counts the sector lists loaded through it
+type countingReader struct {
+	utils.ChainReader
+	loads int
+}
+
+func (r *countingReader) StateMinerSectors(ctx context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	r.loads++
+	return r.ChainReader.StateMinerSectors(ctx, addr, filter, tsk)
+}
+
+func TestSnapshotCacheRelease(t *testing.T) {
+	ctx := context.Background()
+	api := &countingReader{ChainReader: fixtureReader(t)}
+	head, err := api.ChainHead(ctx)
+	require.NoError(t, err)
+
+	// Both future epochs are evaluated on the head snapshot
+	tasks := []MinerTask{
+		{MinerID: testMiner, Epoch: head.Height() + 100},
+		{MinerID: testMiner, Epoch: head.Height() + 200},
+	}
+	cache := newSnapshotCache(api, head.Height(), tasks)
+
+	first, err := cache.get(ctx, tasks[0])
+	require.NoError(t, err)
+	cache.release(tasks[0])
+
+	second, err := cache.get(ctx, tasks[1])
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, api.loads)
+
+	cache.release(tasks[1])
+	assert.Empty(t, cache.snapshots)
+	assert.Empty(t, cache.refs)
+
+	// A task released without loading, e.g. reused from a checkpoint, is counted as well
+	cache = newSnapshotCache(api, head.Height(), tasks[:1])
+	cache.release(tasks[0])
+	assert.Empty(t, cache.refs)
+}
+
+func TestReadCSVFile(t *testing.T) {
+	genesis := time.Unix(1598306400, 0)
+	date, err := utils.ParseTime("2025-01-01")
+	require.NoError(t, err)
+	dateEpoch := utils.TimeToEpoch(date, genesis)
+
+	tests := []struct {
+		name    string
+		content string
+		want    []MinerTask
+		err     string
+	}{
+		{
+			name:    "legacy without header",
+			content: "f01234,2000000\nf05678, 2100000\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000}, {MinerID: "f05678", Epoch: 2100000}},
+		},
+		{
+			name:    "legacy header",
+			content: "minerid,epoch\nf01234,2000000\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000}},
+		},
+		{
+			name:    "time column",
+			content: "miner,time\nf01234,2025-01-01\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: dateEpoch}},
+		},
+		{
+			name:    "date alias with empty epoch",
+			content: "miner,epoch,date\nf01234,,2025-01-01\nf05678,2000000,\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: dateEpoch}, {MinerID: "f05678", Epoch: 2000000}},
+		},
+		{
+			name:    "epoch and time",
+			content: "miner,epoch,time\nf01234,2000000,2025-01-01\n",
+			err:     "line 2 sets both epoch and time",
+		},
+		{
+			name:    "quoted sector list",
+			content: "miner,epoch,sectors\nf01234,2000000,\"1,2,5-10\"\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000, Sectors: "1,2,5-10"}},
+		},
+		{
+			name:    "empty sectors cell",
+			content: "miner,epoch,sectors\nf01234,2000000,\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000}},
+		},
+		{
+			name:    "invalid sectors",
+			content: "miner,epoch,sectors\nf01234,2000000,5-1\n",
+			err:     "invalid sectors at line 2",
+		},
+		{
+			name:    "label and tag alias",
+			content: "Miner_ID,Epoch,Tag\nf01234,2000000,pool-a\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000, Label: "pool-a"}},
+		},
+		{
+			name:    "duplicate alias",
+			content: "miner,epoch,label,tag\nf01234,2000000,a,b\n",
+			err:     "duplicate CSV column: tag",
+		},
+		{
+			name:    "extra columns ignored",
+			content: "miner,owner,epoch,note\nf01234,f0100,2000000,keep\n",
+			want:    []MinerTask{{MinerID: "f01234", Epoch: 2000000}},
+		},
+		{
+			name:    "header without epoch or time",
+			content: "miner,sectors\nf01234,1\n",
+			err:     "CSV header needs an epoch or time column",
+		},
+		{
+			name:    "invalid epoch",
+			content: "f01234,soon\n",
+			err:     "invalid epoch at line 1: soon",
+		},
+		{
+			name:    "invalid time",
+			content: "miner,time\nf01234,someday\n",
+			err:     "invalid time at line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "miners.csv")
+			require.NoError(t, os.WriteFile(filename, []byte(tt.content), 0644))
+
+			tasks, err := readCSVFile(filename, genesis)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tasks)
+		})
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
 	"github.com/strahe/fil-terminator/pkg/utils"
@@ -38,7 +43,25 @@ var calCmd = &cli.Command{
 			Aliases: []string{"e"},
 			Usage:   "Target epoch, use current height if not specified",
 		},
-
+		&cli.Uint64Flag{
+			Name:  "deadline",
+			Usage: "Only calculate the sectors assigned to this deadline (0-47)",
+		},
+		&cli.Uint64Flag{
+			Name:  "partition",
+			Usage: "Only calculate the sectors of this partition of --deadline",
+		},
+		&cli.BoolFlag{
+			Name:  "by-partition",
+			Usage: "Show the termination cost of each deadline and partition (csv output lists partitions instead of sectors)",
+		},
+		modelFlag,
+		modelParamsFlag,
+		modelFileFlag,
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Aliases: []string{"v"},
@@ -48,10 +71,145 @@ var calCmd = &cli.Command{
 	Action: calculate,
 }
 
-func calculate(c *cli.Context) error {
+var modelFlag = &cli.StringFlag{
+	Name:  "model",
+	Usage: "Network projection model used for future estimates (" + strings.Join(utils.NetworkModels(), ", ") + ")",
+	Value: utils.ModelLinear,
+}
+
+var modelParamsFlag = &cli.StringFlag{
+	Name:  "model-params",
+	Usage: "Projection model parameters, comma separated (e.g. power_growth_rate=0.0001,reward_decay_rate=0.00005)",
+}
+
+var modelFileFlag = &cli.StringFlag{
+	Name:  "model-file",
+	Usage: "Load projection model from a file written by the fit command (overrides --model and --model-params)",
+}
+
+var snapshotFlag = &cli.StringFlag{
+	Name:  "snapshot",
+	Usage: "Read chain state from a local CAR export (e.g. from 'lotus chain export') instead of a Lotus node",
+}
+
+var retryDefaults = utils.DefaultRetryPolicy()
+
+var retriesFlag = &cli.IntFlag{
+	Name:  "retries",
+	Usage: "Retry transient Lotus API failures up to this many times per call, 0 disables retries",
+	Value: retryDefaults.MaxAttempts - 1,
+}
+
+var retryBackoffFlag = &cli.DurationFlag{
+	Name:  "retry-backoff",
+	Usage: "Delay before the first retry, doubled after every retry",
+	Value: retryDefaults.InitialBackoff,
+}
+
+var callTimeoutFlag = &cli.DurationFlag{
+	Name:  "call-timeout",
+	Usage: "Timeout of a single Lotus API call, 0 for no timeout",
+	Value: retryDefaults.CallTimeout,
+}
+
+// getRetryPolicy builds the retry policy from --retries, --retry-backoff and --call-timeout,
+// commands without these flags get no retries
+func getRetryPolicy(c *cli.Context) utils.RetryPolicy {
+	return utils.RetryPolicy{
+		MaxAttempts:    c.Int("retries") + 1,
+		InitialBackoff: c.Duration("retry-backoff"),
+		MaxBackoff:     retryDefaults.MaxBackoff,
+		CallTimeout:    c.Duration("call-timeout"),
+	}
+}
+
+// getChainReader opens the chain reader of a command, replaced in tests
+var getChainReader = openChainReader
+
+// openChainReader opens the CAR export given by --snapshot, or connects to the Lotus node
+func openChainReader(c *cli.Context) (utils.ChainReader, func(), error) {
+	if path := c.String("snapshot"); path != "" {
+		r, err := utils.OpenCarReader(c.Context, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, func() { _ = r.Close() }, nil
+	}
+
 	api, closer, err := lcli.GetFullNodeAPIV1(c)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Lotus node: %w", err)
+		return nil, nil, fmt.Errorf("failed to connect to Lotus node: %w", err)
+	}
+	// Retried calls wait for the rate limit too, only batch has --rps
+	limited := utils.NewRateLimitedReader(api, c.Float64("rps"))
+	return utils.NewRetryingReader(limited, getRetryPolicy(c)), closer, nil
+}
+
+// getSectorSelection parses --sectors or --all, an empty result means all sectors
+func getSectorSelection(c *cli.Context) ([]abi.SectorNumber, error) {
+	if !c.Bool("all") && c.String("sectors") == "" {
+		return nil, fmt.Errorf("must specify --sectors or --all")
+	}
+	if c.Bool("all") && c.String("sectors") != "" {
+		return nil, fmt.Errorf("cannot specify both --sectors and --all")
+	}
+	if c.Bool("all") {
+		return nil, nil
+	}
+
+	sectorNumbers, err := utils.ParseSectorNumbers(c.String("sectors"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sector numbers: %w", err)
+	}
+	return sectorNumbers, nil
+}
+
+// getPartitionFilter parses --deadline and --partition, nil means all deadlines
+func getPartitionFilter(c *cli.Context) (*utils.PartitionFilter, error) {
+	if !c.IsSet("deadline") {
+		if c.IsSet("partition") {
+			return nil, fmt.Errorf("--partition requires --deadline")
+		}
+		return nil, nil
+	}
+
+	filter := &utils.PartitionFilter{Deadline: c.Uint64("deadline")}
+	if filter.Deadline >= miner.WPoStPeriodDeadlines {
+		return nil, fmt.Errorf("invalid deadline %d: must be less than %d", filter.Deadline, miner.WPoStPeriodDeadlines)
+	}
+	if c.IsSet("partition") {
+		partition := c.Uint64("partition")
+		filter.Partition = &partition
+	}
+	return filter, nil
+}
+
+// getNetworkModel builds the projection model selected by --model-file, or --model and --model-params
+func getNetworkModel(c *cli.Context) (utils.NetworkModel, error) {
+	if c.String("model-file") != "" {
+		fit, err := utils.LoadModelFit(c.String("model-file"))
+		if err != nil {
+			return nil, err
+		}
+		return fit.NetworkModel()
+	}
+
+	params, err := utils.ParseModelParams(c.String("model-params"))
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewNetworkModel(c.String("model"), params)
+}
+
+func calculate(c *cli.Context) error {
+	format, err := getOutputFormat(c)
+	if err != nil {
+		return err
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
 	}
 	defer closer()
 
@@ -59,32 +217,48 @@ func calculate(c *cli.Context) error {
 	defer cancel()
 
 	// Check parameters
-	if !c.Bool("all") && c.String("sectors") == "" {
-		return fmt.Errorf("must specify --sectors or --all")
+	sectorNumbers, err := getSectorSelection(c)
+	if err != nil {
+		return err
 	}
-	if c.Bool("all") && c.String("sectors") != "" {
-		return fmt.Errorf("cannot specify both --sectors and --all")
+
+	partitions, err := getPartitionFilter(c)
+	if err != nil {
+		return err
+	}
+
+	model, err := getNetworkModel(c)
+	if err != nil {
+		return fmt.Errorf("invalid projection model: %w", err)
 	}
 
 	// Prepare calculation request
 	req := utils.CalculationRequest{
-		MinerID:     c.String("miner"),
-		TargetEpoch: abi.ChainEpoch(c.Int64("epoch")),
+		MinerID:       c.String("miner"),
+		TargetEpoch:   abi.ChainEpoch(c.Int64("epoch")),
+		SectorNumbers: sectorNumbers,
+		Model:         model,
+		Partitions:    partitions,
 	}
 
-	// Parse sector numbers if specified
-	if !c.Bool("all") {
-		sectorNumbers, err := utils.ParseSectorNumbers(c.String("sectors"))
-		if err != nil {
-			return fmt.Errorf("invalid sector numbers: %w", err)
-		}
-		req.SectorNumbers = sectorNumbers
+	// Calculate termination fees
+	result, err := utils.CalculateTerminationFee(ctx, api, req)
+	if err != nil {
+		return err
 	}
 
-	// Calculate termination fees
-	result := utils.CalculateTerminationFee(ctx, api, req)
-	if result.Error != "" {
-		return fmt.Errorf("%s", result.Error)
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		enc := json.NewEncoder(os.Stdout)
+		if format == FormatJSON {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(newCalculationOutput(result))
+	case FormatCSV:
+		if c.Bool("by-partition") {
+			return writeCalculationPartitionsCSV(os.Stdout, newCalculationOutput(result))
+		}
+		return writeCalculationSectorsCSV(os.Stdout, newCalculationOutput(result))
 	}
 
 	// Display mode information
@@ -93,27 +267,15 @@ func calculate(c *cli.Context) error {
 		daysDiff := utils.EpochsToDays(epochDiff)
 		fmt.Printf("Estimation mode: predicting fees for epoch %d (+%.1f days) based on data from epoch %d\n",
 			result.TargetEpoch, daysDiff, result.CurrentEpoch)
+		fmt.Printf("Projection model: %s\n", result.Projection)
 	} else {
 		fmt.Printf("Calculation epoch: %d\n", result.TargetEpoch)
 	}
+	fmt.Printf("Fee formula: %s\n", result.Formula)
 
 	// Display sector details if verbose
 	if c.Bool("verbose") {
-		fmt.Printf("Sector details:\n")
-		for _, sectorResult := range result.SectorResults {
-			if sectorResult.IsExpired {
-				fmt.Printf("  Sector %d: EXPIRED (expired %.1f days ago)\n",
-					sectorResult.SectorNumber, sectorResult.ExpiredDays)
-			} else {
-				status := "historical"
-				if result.IsEstimate {
-					status = "estimated"
-				}
-				ageInDays := utils.EpochsToDays(sectorResult.Age)
-				fmt.Printf("  Sector %d: %s FIL (age: %.1f days, %s)\n",
-					sectorResult.SectorNumber, types.FIL(sectorResult.Fee), ageInDays, status)
-			}
-		}
+		printSectorDetails(os.Stdout, result)
 	}
 
 	// Display summary
@@ -122,7 +284,65 @@ func calculate(c *cli.Context) error {
 		fmt.Printf("Expired sectors: %d\n", result.ExpiredSectors)
 		fmt.Printf("Active sectors: %d\n", result.ActiveSectors)
 	}
+	if result.TerminatedSectors > 0 {
+		fmt.Printf("Terminated sectors (excluded): %d\n", result.TerminatedSectors)
+	}
+	if len(result.StatusTotals) > 1 || (len(result.StatusTotals) == 1 && result.StatusTotals[0].Status != utils.SectorActive) {
+		fmt.Printf("Active sectors by status:\n")
+		for _, st := range result.StatusTotals {
+			fmt.Printf("  %-10s %6d sectors, fee %s, pledge %s\n", st.Status, st.Sectors, types.FIL(st.Fee), types.FIL(st.InitialPledge))
+		}
+	}
+	if c.Bool("by-partition") {
+		printPartitions(os.Stdout, result.PartitionTotals)
+	}
 	fmt.Printf("Total termination fee: %s\n", types.FIL(result.TotalFee))
+	if result.ApproximateSectors > 0 {
+		fmt.Printf("Warning: the fee of %d sectors may be understated, the sectors they replaced by capacity upgrade are unknown before actors v12\n", result.ApproximateSectors)
+	}
 
 	return nil
 }
+
+// printPartitions prints the cost of each partition, immutable deadlines cannot be
+// terminated until their challenge window has passed
+func printPartitions(w io.Writer, totals []utils.PartitionTotal) {
+	fmt.Fprintf(w, "Active sectors by partition:\n")
+	fmt.Fprintf(w, "  %-8s %-9s %-9s %-8s %-20s %s\n", "Deadline", "Partition", "Mutable", "Sectors", "Fee(FIL)", "Pledge(FIL)")
+	for _, pt := range totals {
+		mutable := "yes"
+		if !pt.Mutable {
+			mutable = "no"
+		}
+		fmt.Fprintf(w, "  %-8d %-9d %-9s %-8d %-20s %s\n",
+			pt.Deadline, pt.Partition, mutable, pt.Sectors, types.FIL(pt.Fee), types.FIL(pt.InitialPledge))
+	}
+}
+
+// printSectorDetails prints the fee of each sector along with the terms it was built from
+func printSectorDetails(w io.Writer, result utils.CalculationResult) {
+	fmt.Fprintf(w, "Sector details:\n")
+	for _, sectorResult := range result.SectorResults {
+		if sectorResult.IsExpired {
+			fmt.Fprintf(w, "  Sector %d: EXPIRED (expired %.1f days ago)\n",
+				sectorResult.SectorNumber, sectorResult.ExpiredDays)
+		} else {
+			status := "historical"
+			if result.IsEstimate {
+				status = "estimated"
+			}
+			if sectorResult.Status != utils.SectorActive {
+				status += ", " + string(sectorResult.Status)
+			}
+			if sectorResult.Status != utils.SectorUnknown {
+				status += fmt.Sprintf(", deadline %d partition %d", sectorResult.Deadline, sectorResult.Partition)
+			}
+			ageInDays := utils.EpochsToDays(sectorResult.Age)
+			fmt.Fprintf(w, "  Sector %d: %s FIL (age: %.1f days, %s)\n",
+				sectorResult.SectorNumber, types.FIL(sectorResult.Fee), ageInDays, status)
+			fmt.Fprintf(w, "    initial pledge: %s, fault fee: %s, QA power: %s, binding term: %s\n",
+				types.FIL(sectorResult.InitialPledge), types.FIL(sectorResult.FaultFee),
+				types.SizeStr(sectorResult.QAPower), sectorResult.BindingTerm)
+		}
+	}
+}
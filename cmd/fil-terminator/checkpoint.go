@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// checkpointEntry is one line of the checkpoint journal. It is keyed by the task as read
+// from the input, which can differ from the result, e.g. epoch 0 resolves to the head,
+// along with the projection model in effect, so a resume with another model recalculates.
+type checkpointEntry struct {
+	MinerID string         `json:"miner_id"`
+	Epoch   abi.ChainEpoch `json:"epoch"`
+	Sectors string         `json:"sectors,omitempty"`
+	Label   string         `json:"label,omitempty"`
+	Model   string         `json:"model,omitempty"`
+	Result  MinerResult    `json:"result"`
+}
+
+// checkpoint is an append-only journal of batch results, one JSON line per finished task.
+// A later line for the same task replaces an earlier one.
+type checkpoint struct {
+	lk   sync.Mutex
+	file *os.File
+	done map[MinerTask]MinerResult
+}
+
+// openCheckpoint opens the journal at filename. With resume the results already in the
+// journal are kept, otherwise a new journal is started and an existing one is left alone.
+func openCheckpoint(filename string, resume bool) (*checkpoint, error) {
+	cp := &checkpoint{done: make(map[MinerTask]MinerResult)}
+	if !resume {
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%s already exists, continue it with --resume or remove it", filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cp.file = file
+		return cp, nil
+	}
+
+	if err := cp.load(filename); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the kept results to a fresh journal, dropping replaced entries and a
+	// line cut short by the interrupted run, then continue appending to it
+	tmp := filename + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	cp.file = file
+	for task, result := range cp.done {
+		if err := cp.Record(task, result); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) load(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var pending error
+	for line := 1; scanner.Scan(); line++ {
+		// Only the last line may be cut short by an interrupted run
+		if pending != nil {
+			return pending
+		}
+
+		var entry checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			pending = fmt.Errorf("invalid checkpoint entry at line %d: %w", line, err)
+			continue
+		}
+		cp.done[MinerTask{MinerID: entry.MinerID, Epoch: entry.Epoch, Sectors: entry.Sectors, Label: entry.Label, Model: entry.Model}] = entry.Result
+	}
+	return scanner.Err()
+}
+
+// Succeeded returns the journaled result of a task if it finished without error
+func (cp *checkpoint) Succeeded(task MinerTask) (MinerResult, bool) {
+	cp.lk.Lock()
+	defer cp.lk.Unlock()
+	result, ok := cp.done[task]
+	if !ok || result.Error != "" {
+		return MinerResult{}, false
+	}
+	return result, true
+}
+
+// Record appends a finished task to the journal, it is safe for concurrent use
+func (cp *checkpoint) Record(task MinerTask, result MinerResult) error {
+	data, err := json.Marshal(&checkpointEntry{
+		MinerID: task.MinerID,
+		Epoch:   task.Epoch,
+		Sectors: task.Sectors,
+		Label:   task.Label,
+		Model:   task.Model,
+		Result:  result,
+	})
+	if err != nil {
+		return err
+	}
+
+	cp.lk.Lock()
+	defer cp.lk.Unlock()
+	if _, err := cp.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	cp.done[task] = result
+	return cp.file.Sync()
+}
+
+func (cp *checkpoint) Close() error {
+	return cp.file.Close()
+}
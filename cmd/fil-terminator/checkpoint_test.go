@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tasks on three miner states: the head, epoch 900000 and two sectors at the head
+const checkpointInput = `minerid,epoch,sectors,label
+f01234,0,,a
+f01234,1200000,,b
+f01234,900000,,c
+f01234,1100000,"2,3",d
+f01234,1300000,,e
+`
+
+// interruptingReader cancels the run when the given miner state load starts
+type interruptingReader struct {
+	utils.ChainReader
+	cancel func()
+	after  int // miner info reads before the cancel
+	calls  int
+}
+
+func (r *interruptingReader) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	if r.calls++; r.calls > r.after {
+		r.cancel()
+	}
+	return r.ChainReader.StateMinerInfo(ctx, addr, tsk)
+}
+
+func TestBatchResumeMatchesCleanRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "miners.csv")
+	require.NoError(t, os.WriteFile(input, []byte(checkpointInput), 0644))
+	journal := filepath.Join(dir, "batch.journal")
+	clean, resumed := filepath.Join(dir, "clean.csv"), filepath.Join(dir, "resumed.csv")
+	cleanSectors, resumedSectors := filepath.Join(dir, "clean-sectors.csv"), filepath.Join(dir, "resumed-sectors.csv")
+
+	require.NoError(t, runApp(t, context.Background(), fixtureReader(t, "historical", "sector-list"),
+		"batch", "--input", input, "--output", clean, "--sectors-output", cleanSectors))
+
+	// Interrupted while loading the second miner state, after the first two tasks
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := &interruptingReader{ChainReader: fixtureReader(t, "historical", "sector-list"), cancel: cancel, after: 1}
+	err := runApp(t, ctx, reader, "batch", "--input", input, "--output", resumed, "--checkpoint", journal, "-j", "1")
+	require.ErrorIs(t, err, context.Canceled)
+
+	cp, err := openCheckpoint(journal, true)
+	require.NoError(t, err)
+	assert.Len(t, cp.done, 2)
+	require.NoError(t, cp.Close())
+
+	require.NoError(t, runApp(t, context.Background(), fixtureReader(t, "historical", "sector-list"),
+		"batch", "--input", input, "--output", resumed, "--checkpoint", journal, "--resume", "--sectors-output", resumedSectors))
+
+	want, err := os.ReadFile(clean)
+	require.NoError(t, err)
+	got, err := os.ReadFile(resumed)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+
+	// Sectors of the reused miners are recomputed
+	want, err = os.ReadFile(cleanSectors)
+	require.NoError(t, err)
+	got, err = os.ReadFile(resumedSectors)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestBatchResumeWithOtherModel(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "miners.csv")
+	require.NoError(t, os.WriteFile(input, []byte(checkpointInput), 0644))
+	journal := filepath.Join(dir, "batch.journal")
+	clean, resumed := filepath.Join(dir, "clean.csv"), filepath.Join(dir, "resumed.csv")
+	reader := fixtureReader(t, "historical", "sector-list")
+
+	require.NoError(t, runApp(t, context.Background(), reader, "batch", "--input", input, "--output", resumed, "--checkpoint", journal))
+	require.NoError(t, runApp(t, context.Background(), reader, "batch", "--input", input, "--output", clean, "--model", "frozen"))
+
+	// Results journaled under the default model are not reused for another one
+	require.NoError(t, runApp(t, context.Background(), reader,
+		"batch", "--input", input, "--output", resumed, "--checkpoint", journal, "--resume", "--model", "frozen"))
+
+	want, err := os.ReadFile(clean)
+	require.NoError(t, err)
+	got, err := os.ReadFile(resumed)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestOpenCheckpointKeepsExistingJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "batch.journal")
+	cp, err := openCheckpoint(journal, false)
+	require.NoError(t, err)
+	require.NoError(t, cp.Record(MinerTask{MinerID: testMiner}, MinerResult{MinerID: testMiner, Status: "success"}))
+	require.NoError(t, cp.Close())
+
+	_, err = openCheckpoint(journal, false)
+	assert.ErrorContains(t, err, "--resume")
+
+	cp, err = openCheckpoint(journal, true)
+	require.NoError(t, err)
+	_, ok := cp.Succeeded(MinerTask{MinerID: testMiner})
+	assert.True(t, ok)
+	require.NoError(t, cp.Close())
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var expirationCmd = &cli.Command{
+	Name:    "expiration",
+	Aliases: []string{"expiry"},
+	Usage:   "Compare terminating sectors with keeping them until expiration",
+	Description: "For each sector, compare the termination fee at the target epoch with the expected block rewards\n" +
+		"forgone until its expiration and the fault penalties it pays if kept but left unproven. Results are\n" +
+		"aggregated by miner. Operating costs of keeping a sector are not included.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "miner",
+			Aliases:  []string{"m"},
+			Usage:    "Miner address, repeat or separate with commas for several miners",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "sectors",
+			Aliases: []string{"s"},
+			Usage:   "Sector number list, comma separated (e.g. 1,2,3 or 1-10), applied to every miner",
+		},
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Compare all sectors",
+		},
+		&cli.Int64Flag{
+			Name:    "epoch",
+			Aliases: []string{"e"},
+			Usage:   "Epoch of the termination, use current height if not specified",
+		},
+		modelFlag,
+		modelParamsFlag,
+		modelFileFlag,
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Show every sector",
+		},
+	},
+	Action: expiration,
+}
+
+// ExpirationOutput is the machine readable form of a utils.ExpirationAnalysis. Amounts are
+// given in attoFIL with full precision, the *_fil fields repeat them as FIL strings.
+type ExpirationOutput struct {
+	MinerID           string                   `json:"miner_id"`
+	TargetEpoch       abi.ChainEpoch           `json:"target_epoch"`
+	IsEstimate        bool                     `json:"is_estimate"`
+	Projection        *ProjectionOutput        `json:"projection,omitempty"`
+	ComparedSectors   int                      `json:"compared_sectors"`
+	ExpiredSectors    int                      `json:"expired_sectors"`
+	Fee               string                   `json:"fee"`
+	FeeFIL            string                   `json:"fee_fil"`
+	RewardsForgone    string                   `json:"rewards_forgone"`
+	RewardsForgoneFIL string                   `json:"rewards_forgone_fil"`
+	FaultPenalty      string                   `json:"fault_penalty"`
+	FaultPenaltyFIL   string                   `json:"fault_penalty_fil"`
+	KeepNet           string                   `json:"keep_net"` // gain of keeping the sectors proven
+	KeepNetFIL        string                   `json:"keep_net_fil"`
+	UnprovenNet       string                   `json:"unproven_net"` // gain of leaving them unproven, negative if terminating is cheaper
+	UnprovenNetFIL    string                   `json:"unproven_net_fil"`
+	Sectors           []SectorExpirationOutput `json:"sectors"`
+}
+
+type SectorExpirationOutput struct {
+	SectorNumber      abi.SectorNumber   `json:"sector_number"`
+	Status            utils.SectorStatus `json:"status"`
+	Expiration        abi.ChainEpoch     `json:"expiration"`
+	RemainingDays     float64            `json:"remaining_days"`
+	Fee               string             `json:"fee"`
+	FeeFIL            string             `json:"fee_fil"`
+	RewardsForgone    string             `json:"rewards_forgone"`
+	RewardsForgoneFIL string             `json:"rewards_forgone_fil"`
+	FaultPenalty      string             `json:"fault_penalty"`
+	FaultPenaltyFIL   string             `json:"fault_penalty_fil"`
+	AutoTerminated    bool               `json:"auto_terminated"`
+	KeepNet           string             `json:"keep_net"`
+	KeepNetFIL        string             `json:"keep_net_fil"`
+	UnprovenNet       string             `json:"unproven_net"`
+	UnprovenNetFIL    string             `json:"unproven_net_fil"`
+}
+
+func newExpirationOutput(a utils.ExpirationAnalysis) ExpirationOutput {
+	out := ExpirationOutput{
+		MinerID:           a.MinerID,
+		TargetEpoch:       a.TargetEpoch,
+		IsEstimate:        a.IsEstimate,
+		ComparedSectors:   len(a.Sectors),
+		ExpiredSectors:    a.ExpiredSectors,
+		Fee:               bigString(a.Fee),
+		FeeFIL:            types.FIL(a.Fee).String(),
+		RewardsForgone:    bigString(a.RewardsForgone),
+		RewardsForgoneFIL: types.FIL(a.RewardsForgone).String(),
+		FaultPenalty:      bigString(a.FaultPenalty),
+		FaultPenaltyFIL:   types.FIL(a.FaultPenalty).String(),
+		KeepNet:           bigString(a.KeepNet),
+		KeepNetFIL:        types.FIL(a.KeepNet).String(),
+		UnprovenNet:       bigString(a.UnprovenNet),
+		UnprovenNetFIL:    types.FIL(a.UnprovenNet).String(),
+		Sectors:           make([]SectorExpirationOutput, 0, len(a.Sectors)),
+	}
+	if p := a.Projection; p != nil {
+		out.Projection = &ProjectionOutput{Model: p.Model, Epochs: p.Epochs, Params: p.Params}
+	}
+
+	for _, s := range a.Sectors {
+		out.Sectors = append(out.Sectors, SectorExpirationOutput{
+			SectorNumber:      s.SectorNumber,
+			Status:            s.Status,
+			Expiration:        s.Expiration,
+			RemainingDays:     utils.EpochsToDays(s.Remaining),
+			Fee:               bigString(s.Fee),
+			FeeFIL:            types.FIL(s.Fee).String(),
+			RewardsForgone:    bigString(s.RewardsForgone),
+			RewardsForgoneFIL: types.FIL(s.RewardsForgone).String(),
+			FaultPenalty:      bigString(s.FaultPenalty),
+			FaultPenaltyFIL:   types.FIL(s.FaultPenalty).String(),
+			AutoTerminated:    s.AutoTerminated,
+			KeepNet:           bigString(s.KeepNet),
+			KeepNetFIL:        types.FIL(s.KeepNet).String(),
+			UnprovenNet:       bigString(s.UnprovenNet),
+			UnprovenNetFIL:    types.FIL(s.UnprovenNet).String(),
+		})
+	}
+	return out
+}
+
+func expiration(c *cli.Context) error {
+	format, err := getOutputFormat(c)
+	if err != nil {
+		return err
+	}
+
+	sectorNumbers, err := getSectorSelection(c)
+	if err != nil {
+		return err
+	}
+
+	model, err := getNetworkModel(c)
+	if err != nil {
+		return fmt.Errorf("invalid projection model: %w", err)
+	}
+
+	var miners []string
+	for _, m := range c.StringSlice("miner") {
+		if m = strings.TrimSpace(m); m != "" {
+			miners = append(miners, m)
+		}
+	}
+	if len(miners) == 0 {
+		return fmt.Errorf("no miner specified")
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	epoch := abi.ChainEpoch(c.Int64("epoch"))
+	analyses := make([]utils.ExpirationAnalysis, 0, len(miners))
+	for _, minerID := range miners {
+		snap, err := utils.LoadSnapshot(ctx, api, minerID, epoch, sectorNumbers)
+		if err != nil {
+			return err
+		}
+		analysis, err := snap.CompareExpiration(epoch, model)
+		if err != nil {
+			return err
+		}
+		analyses = append(analyses, analysis)
+	}
+
+	switch format {
+	case FormatJSON:
+		records := make([]ExpirationOutput, 0, len(analyses))
+		for _, a := range analyses {
+			records = append(records, newExpirationOutput(a))
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatNDJSON:
+		enc := json.NewEncoder(os.Stdout)
+		for _, a := range analyses {
+			if err := enc.Encode(newExpirationOutput(a)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		return writeExpirationCSV(os.Stdout, analyses)
+	}
+
+	if c.Bool("verbose") {
+		for _, a := range analyses {
+			printSectorExpirations(os.Stdout, a)
+		}
+	}
+	printExpirations(os.Stdout, analyses)
+	return nil
+}
+
+// writeExpirationCSV writes the totals of each miner
+func writeExpirationCSV(w io.Writer, analyses []utils.ExpirationAnalysis) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := []string{"MinerID", "Epoch", "Sectors", "Expired", "Fee(FIL)", "RewardsForgone(FIL)", "FaultPenalty(FIL)", "KeepNet(FIL)", "UnprovenNet(FIL)"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, a := range analyses {
+		record := []string{
+			a.MinerID,
+			fmt.Sprintf("%d", a.TargetEpoch),
+			fmt.Sprintf("%d", len(a.Sectors)),
+			fmt.Sprintf("%d", a.ExpiredSectors),
+			types.FIL(a.Fee).String(),
+			types.FIL(a.RewardsForgone).String(),
+			types.FIL(a.FaultPenalty).String(),
+			types.FIL(a.KeepNet).String(),
+			types.FIL(a.UnprovenNet).String(),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printSectorExpirations(w io.Writer, a utils.ExpirationAnalysis) {
+	fmt.Fprintf(w, "=== %s at epoch %d ===\n", a.MinerID, a.TargetEpoch)
+	fmt.Fprintf(w, "%-10s %-10s %-10s %-28s %-28s %-28s %-28s %s\n",
+		"Sector", "Status", "Days left", "Fee(FIL)", "Rewards(FIL)", "FaultPenalty(FIL)", "KeepNet(FIL)", "UnprovenNet(FIL)")
+	for _, s := range a.Sectors {
+		penalty := types.FIL(s.FaultPenalty).String()
+		if s.AutoTerminated {
+			penalty += "*"
+		}
+		fmt.Fprintf(w, "%-10d %-10s %-10.1f %-28s %-28s %-28s %-28s %s\n",
+			s.SectorNumber, s.Status, utils.EpochsToDays(s.Remaining),
+			types.FIL(s.Fee), types.FIL(s.RewardsForgone), penalty, types.FIL(s.KeepNet), types.FIL(s.UnprovenNet))
+	}
+	fmt.Fprintf(w, "* terminated automatically after %.0f days unproven, fee included\n\n", utils.EpochsToDays(stactorsminer.FaultMaxAge))
+}
+
+func printExpirations(w io.Writer, analyses []utils.ExpirationAnalysis) {
+	fmt.Fprintf(w, "%-12s %-8s %-28s %-28s %-28s %-28s %s\n",
+		"Miner", "Sectors", "Fee(FIL)", "Rewards(FIL)", "FaultPenalty(FIL)", "KeepNet(FIL)", "UnprovenNet(FIL)")
+	fmt.Fprintln(w, strings.Repeat("-", 168))
+
+	total := utils.ExpirationAnalysis{Fee: big.Zero(), RewardsForgone: big.Zero(), FaultPenalty: big.Zero(), KeepNet: big.Zero(), UnprovenNet: big.Zero()}
+	sectors := 0
+	for _, a := range analyses {
+		fmt.Fprintf(w, "%-12s %-8d %-28s %-28s %-28s %-28s %s\n",
+			a.MinerID, len(a.Sectors), types.FIL(a.Fee), types.FIL(a.RewardsForgone), types.FIL(a.FaultPenalty), types.FIL(a.KeepNet), types.FIL(a.UnprovenNet))
+		sectors += len(a.Sectors)
+		total.Fee = big.Add(total.Fee, a.Fee)
+		total.RewardsForgone = big.Add(total.RewardsForgone, a.RewardsForgone)
+		total.FaultPenalty = big.Add(total.FaultPenalty, a.FaultPenalty)
+		total.KeepNet = big.Add(total.KeepNet, a.KeepNet)
+		total.UnprovenNet = big.Add(total.UnprovenNet, a.UnprovenNet)
+	}
+	if len(analyses) > 1 {
+		fmt.Fprintf(w, "%-12s %-8d %-28s %-28s %-28s %-28s %s\n",
+			"Total", sectors, types.FIL(total.Fee), types.FIL(total.RewardsForgone), types.FIL(total.FaultPenalty), types.FIL(total.KeepNet), types.FIL(total.UnprovenNet))
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "KeepNet: fee saved plus rewards earned by keeping the sectors proven until expiration, before operating costs")
+	fmt.Fprintln(w, "UnprovenNet: fee saved minus fault penalties by leaving the sectors unproven, negative if terminating now is cheaper")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var fitCmd = &cli.Command{
+	Name:        "fit",
+	Usage:       "Fit projection model parameters from chain history",
+	Description: "Sample the smoothed reward and power estimates over a lookback window and fit growth/decay rates for future estimation. The result can be reused offline with --model-file.",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:    "epoch",
+			Aliases: []string{"e"},
+			Usage:   "End epoch of the lookback window, use current height if not specified",
+		},
+		&cli.Int64Flag{
+			Name:  "lookback",
+			Usage: "Lookback window in epochs",
+			Value: 30 * 2880,
+		},
+		&cli.Int64Flag{
+			Name:  "step",
+			Usage: "Sampling interval in epochs",
+			Value: 2880,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output model file path",
+			Value:   "model.json",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Verbose output",
+		},
+	},
+	Action: fitModel,
+}
+
+func fitModel(c *cli.Context) error {
+	api, closer, err := lcli.GetFullNodeAPIV1(c)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Lotus node: %w", err)
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	samples, err := utils.SampleNetwork(ctx, api,
+		abi.ChainEpoch(c.Int64("epoch")),
+		abi.ChainEpoch(c.Int64("lookback")),
+		abi.ChainEpoch(c.Int64("step")))
+	if err != nil {
+		return fmt.Errorf("failed to sample network: %w", err)
+	}
+
+	if c.Bool("verbose") {
+		fmt.Printf("Samples:\n")
+		for _, s := range samples {
+			fmt.Printf("  Epoch %d: reward %s, power %s\n", s.Epoch,
+				s.Reward.PositionEstimate, s.Power.PositionEstimate)
+		}
+	}
+
+	fit, err := utils.FitNetworkModel(samples)
+	if err != nil {
+		return fmt.Errorf("failed to fit model: %w", err)
+	}
+
+	fmt.Printf("Fitted %d samples from epoch %d to %d (step %d)\n", fit.Samples, fit.StartEpoch, fit.EndEpoch, fit.Step)
+	fmt.Printf("Model: %s(%s)\n", fit.Model, utils.FormatModelParams(fit.Params))
+	fmt.Printf("Power fit R²: %.4f\n", fit.PowerR2)
+	fmt.Printf("Reward fit R²: %.4f\n", fit.RewardR2)
+
+	if err := utils.SaveModelFit(c.String("output"), fit); err != nil {
+		return fmt.Errorf("failed to write model file: %w", err)
+	}
+	fmt.Printf("Model written to %s\n", c.String("output"))
+
+	return nil
+}
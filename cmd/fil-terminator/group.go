@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	fbig "github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Keys batch results can be grouped by with --group-by
+const (
+	GroupByOwner       = "owner"
+	GroupByWorker      = "worker"
+	GroupByBeneficiary = "beneficiary"
+	GroupByLabel       = "label"
+)
+
+// noGroup collects results without a group key, e.g. failed miners whose info is unknown
+const noGroup = "(none)"
+
+// parseGroupBy validates a --group-by value, tag is accepted for label as in the CSV input
+func parseGroupBy(by string) (string, error) {
+	switch by = strings.ToLower(strings.TrimSpace(by)); by {
+	case GroupByOwner, GroupByWorker, GroupByBeneficiary, GroupByLabel:
+		return by, nil
+	case "tag":
+		return GroupByLabel, nil
+	default:
+		return "", fmt.Errorf("unsupported group key: %s (available: owner, worker, beneficiary, label)", by)
+	}
+}
+
+// GroupResult sums the batch results of all miners sharing a group key at one epoch.
+// Failed results are only counted in Failed. A miner listed again with the same sectors
+// would be counted twice, only its first result is summed and the others are counted in
+// Duplicates.
+type GroupResult struct {
+	Key            string
+	Epoch          abi.ChainEpoch
+	Miners         int // distinct miners
+	Failed         int
+	Duplicates     int
+	ActiveSectors  int
+	ExpiredSectors int
+	TotalFee       fbig.Int
+	TotalPledge    fbig.Int
+}
+
+// FeePledgePercent returns the total fee as a percentage of the total pledge, false if
+// the group has no pledge
+func (g GroupResult) FeePledgePercent() (float64, bool) {
+	if g.TotalPledge.Int == nil || g.TotalPledge.Sign() <= 0 {
+		return 0, false
+	}
+	ratio := new(big.Rat).SetFrac(g.TotalFee.Int, g.TotalPledge.Int)
+	percent, _ := ratio.Mul(ratio, big.NewRat(100, 1)).Float64()
+	return percent, true
+}
+
+// groupResults aggregates results by key and epoch, groups are ordered by their first result
+func groupResults(results []MinerResult, by string) []GroupResult {
+	type groupID struct {
+		key   string
+		epoch abi.ChainEpoch
+	}
+	type sectorsID struct {
+		miner, sectors string
+	}
+
+	var groups []GroupResult
+	index := make(map[groupID]int)
+	miners := make(map[groupID]map[string]bool)
+	seen := make(map[groupID]map[sectorsID]bool)
+
+	for _, r := range results {
+		id := groupID{groupKey(r, by), r.Epoch}
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			miners[id] = make(map[string]bool)
+			seen[id] = make(map[sectorsID]bool)
+			groups = append(groups, GroupResult{Key: id.key, Epoch: id.epoch, TotalFee: fbig.Zero(), TotalPledge: fbig.Zero()})
+		}
+
+		g := &groups[i]
+		s := sectorsID{r.MinerID, r.Sectors}
+		if seen[id][s] {
+			g.Duplicates++
+			continue
+		}
+		seen[id][s] = true
+		if !miners[id][r.MinerID] {
+			miners[id][r.MinerID] = true
+			g.Miners++
+		}
+		if r.Error != "" {
+			g.Failed++
+			continue
+		}
+		g.ActiveSectors += r.ActiveSectors
+		g.ExpiredSectors += r.ExpiredSectors
+		g.TotalFee = fbig.Add(g.TotalFee, r.TotalFee)
+		g.TotalPledge = fbig.Add(g.TotalPledge, r.TotalPledge)
+	}
+	return groups
+}
+
+func groupKey(r MinerResult, by string) string {
+	var key string
+	switch by {
+	case GroupByOwner:
+		key = r.Owner
+	case GroupByWorker:
+		key = r.Worker
+	case GroupByBeneficiary:
+		key = r.Beneficiary
+	case GroupByLabel:
+		key = r.Label
+	}
+	if key == "" {
+		return noGroup
+	}
+	return key
+}
+
+// GroupOutput is the machine readable form of a GroupResult
+type GroupOutput struct {
+	GroupBy          string         `json:"group_by"`
+	Group            string         `json:"group"`
+	Epoch            abi.ChainEpoch `json:"epoch"`
+	Miners           int            `json:"miners"`
+	Failed           int            `json:"failed"`
+	Duplicates       int            `json:"duplicates"` // results of a miner and sector list after the first, not summed
+	ActiveSectors    int            `json:"active_sectors"`
+	ExpiredSectors   int            `json:"expired_sectors"`
+	TotalFee         string         `json:"total_fee"`
+	TotalFeeFIL      string         `json:"total_fee_fil"`
+	TotalPledge      string         `json:"total_pledge"`
+	TotalPledgeFIL   string         `json:"total_pledge_fil"`
+	FeePledgePercent *float64       `json:"fee_pledge_percent,omitempty"` // omitted without pledge
+}
+
+func newGroupOutput(by string, g GroupResult) GroupOutput {
+	out := GroupOutput{
+		GroupBy:        by,
+		Group:          g.Key,
+		Epoch:          g.Epoch,
+		Miners:         g.Miners,
+		Failed:         g.Failed,
+		Duplicates:     g.Duplicates,
+		ActiveSectors:  g.ActiveSectors,
+		ExpiredSectors: g.ExpiredSectors,
+		TotalFee:       bigString(g.TotalFee),
+		TotalFeeFIL:    types.FIL(g.TotalFee).String(),
+		TotalPledge:    bigString(g.TotalPledge),
+		TotalPledgeFIL: types.FIL(g.TotalPledge).String(),
+	}
+	if percent, ok := g.FeePledgePercent(); ok {
+		out.FeePledgePercent = &percent
+	}
+	return out
+}
+
+// formatPercent formats the fee to pledge percentage of a group, "-" without pledge
+func formatPercent(g GroupResult) string {
+	percent, ok := g.FeePledgePercent()
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", percent)
+}
+
+// writeGroups writes the group report in format to filename, text is written as CSV
+func writeGroups(format, filename, by string, groups []GroupResult) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := encodeGroups(file, format, by, groups); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func encodeGroups(w io.Writer, format, by string, groups []GroupResult) error {
+	switch format {
+	case FormatText, FormatCSV:
+		return writeGroupsCSV(w, by, groups)
+	case FormatJSON:
+		records := make([]GroupOutput, 0, len(groups))
+		for _, g := range groups {
+			records = append(records, newGroupOutput(by, g))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, g := range groups {
+			if err := enc.Encode(newGroupOutput(by, g)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+func writeGroupsCSV(w io.Writer, by string, groups []GroupResult) error {
+	writer := csv.NewWriter(w)
+	header := []string{"GroupBy", "Group", "Epoch", "Miners", "Failed", "ActiveSectors", "ExpiredSectors", "TotalFee(FIL)", "InitialPledge(FIL)", "FeePledgePercent", "TotalFee(attoFIL)", "InitialPledge(attoFIL)", "Duplicates"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		percent := ""
+		if p, ok := g.FeePledgePercent(); ok {
+			percent = fmt.Sprintf("%.4f", p)
+		}
+		record := []string{
+			by,
+			g.Key,
+			fmt.Sprintf("%d", g.Epoch),
+			fmt.Sprintf("%d", g.Miners),
+			fmt.Sprintf("%d", g.Failed),
+			fmt.Sprintf("%d", g.ActiveSectors),
+			fmt.Sprintf("%d", g.ExpiredSectors),
+			types.FIL(g.TotalFee).String(),
+			types.FIL(g.TotalPledge).String(),
+			percent,
+			bigString(g.TotalFee),
+			bigString(g.TotalPledge),
+			fmt.Sprintf("%d", g.Duplicates),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func printGroups(w io.Writer, by string, groups []GroupResult) {
+	fmt.Fprintf(w, "\n=== Groups by %s ===\n", by)
+	fmt.Fprintf(w, "%-24s %-10s %-6s %-6s %-8s %-8s %-20s %-20s %s\n",
+		"Group", "Epoch", "Miners", "Failed", "Active", "Expired", "Fee(FIL)", "Pledge(FIL)", "Fee/Pledge")
+	fmt.Fprintln(w, strings.Repeat("-", 117))
+
+	for _, g := range groups {
+		fmt.Fprintf(w, "%-24s %-10d %-6d %-6d %-8d %-8d %-20s %-20s %s\n",
+			g.Key,
+			g.Epoch,
+			g.Miners,
+			g.Failed,
+			g.ActiveSectors,
+			g.ExpiredSectors,
+			types.FIL(g.TotalFee).String(),
+			types.FIL(g.TotalPledge).String(),
+			formatPercent(g),
+		)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	fbig "github.com/filecoin-project/go-state-types/big"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupResults(t *testing.T) {
+	result := func(miner string, epoch abi.ChainEpoch, fee, pledge int64) MinerResult {
+		return MinerResult{
+			MinerID:       miner,
+			Epoch:         epoch,
+			ActiveSectors: 1,
+			TotalFee:      fbig.NewInt(fee),
+			TotalPledge:   fbig.NewInt(pledge),
+			Label:         "pool",
+			Owner:         "f0100",
+			Worker:        "f0200",
+			Beneficiary:   "f0300",
+		}
+	}
+	failed := func(miner string) MinerResult {
+		return MinerResult{MinerID: miner, Epoch: 1000, TotalFee: fbig.Zero(), TotalPledge: fbig.Zero(), Error: "not found"}
+	}
+	with := func(r MinerResult, edit func(*MinerResult)) MinerResult {
+		edit(&r)
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		by      string
+		results []MinerResult
+		want    []GroupResult
+	}{
+		{
+			name: "owner",
+			by:   GroupByOwner,
+			results: []MinerResult{
+				result("f01", 1000, 10, 100),
+				result("f02", 1000, 20, 200),
+				with(result("f03", 1000, 5, 50), func(r *MinerResult) { r.Owner = "f0101" }),
+			},
+			want: []GroupResult{
+				{Key: "f0100", Epoch: 1000, Miners: 2, ActiveSectors: 2, TotalFee: fbig.NewInt(30), TotalPledge: fbig.NewInt(300)},
+				{Key: "f0101", Epoch: 1000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(5), TotalPledge: fbig.NewInt(50)},
+			},
+		},
+		{
+			name:    "worker",
+			by:      GroupByWorker,
+			results: []MinerResult{result("f01", 1000, 10, 100), result("f02", 1000, 20, 200)},
+			want: []GroupResult{
+				{Key: "f0200", Epoch: 1000, Miners: 2, ActiveSectors: 2, TotalFee: fbig.NewInt(30), TotalPledge: fbig.NewInt(300)},
+			},
+		},
+		{
+			name:    "beneficiary",
+			by:      GroupByBeneficiary,
+			results: []MinerResult{result("f01", 1000, 10, 100), result("f02", 1000, 20, 200)},
+			want: []GroupResult{
+				{Key: "f0300", Epoch: 1000, Miners: 2, ActiveSectors: 2, TotalFee: fbig.NewInt(30), TotalPledge: fbig.NewInt(300)},
+			},
+		},
+		{
+			name: "label without key",
+			by:   GroupByLabel,
+			results: []MinerResult{
+				result("f01", 1000, 10, 100),
+				with(result("f02", 1000, 20, 200), func(r *MinerResult) { r.Label = "" }),
+			},
+			want: []GroupResult{
+				{Key: "pool", Epoch: 1000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(10), TotalPledge: fbig.NewInt(100)},
+				{Key: noGroup, Epoch: 1000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(20), TotalPledge: fbig.NewInt(200)},
+			},
+		},
+		{
+			name:    "failed rows",
+			by:      GroupByOwner,
+			results: []MinerResult{result("f01", 1000, 10, 100), failed("f02"), failed("f03")},
+			want: []GroupResult{
+				{Key: "f0100", Epoch: 1000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(10), TotalPledge: fbig.NewInt(100)},
+				{Key: noGroup, Epoch: 1000, Miners: 2, Failed: 2, TotalFee: fbig.Zero(), TotalPledge: fbig.Zero()},
+			},
+		},
+		{
+			name: "duplicate miner",
+			by:   GroupByLabel,
+			results: []MinerResult{
+				result("f01", 1000, 10, 100),
+				result("f01", 1000, 10, 100),
+				with(result("f01", 1000, 3, 30), func(r *MinerResult) { r.Sectors = "1-3" }),
+			},
+			want: []GroupResult{
+				{Key: "pool", Epoch: 1000, Miners: 1, Duplicates: 1, ActiveSectors: 2, TotalFee: fbig.NewInt(13), TotalPledge: fbig.NewInt(130)},
+			},
+		},
+		{
+			name:    "miner at several epochs",
+			by:      GroupByLabel,
+			results: []MinerResult{result("f01", 1000, 10, 100), result("f01", 2000, 15, 100)},
+			want: []GroupResult{
+				{Key: "pool", Epoch: 1000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(10), TotalPledge: fbig.NewInt(100)},
+				{Key: "pool", Epoch: 2000, Miners: 1, ActiveSectors: 1, TotalFee: fbig.NewInt(15), TotalPledge: fbig.NewInt(100)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := groupResults(tt.results, tt.by)
+			require.Len(t, groups, len(tt.want))
+			for i, want := range tt.want {
+				got := groups[i]
+				assert.Equal(t, want.Key, got.Key)
+				assert.Equal(t, want.Epoch, got.Epoch)
+				assert.Equal(t, want.Miners, got.Miners)
+				assert.Equal(t, want.Failed, got.Failed)
+				assert.Equal(t, want.Duplicates, got.Duplicates)
+				assert.Equal(t, want.ActiveSectors, got.ActiveSectors)
+				assert.Equal(t, want.TotalFee.String(), got.TotalFee.String())
+				assert.Equal(t, want.TotalPledge.String(), got.TotalPledge.String())
+			}
+		})
+	}
+}
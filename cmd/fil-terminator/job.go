@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// BatchJob is a batch run described by a JSON or YAML file. Model settings of a task
+// replace those of the job, which replace the command line flags.
+type BatchJob struct {
+	ModelSpec `yaml:",inline"`
+
+	Output        string `yaml:"output"`
+	OutputFormat  string `yaml:"output_format"`
+	SectorsOutput string `yaml:"sectors_output"`
+	SectorsFormat string `yaml:"sectors_format"`
+	GroupBy       string `yaml:"group_by"`
+	GroupOutput   string `yaml:"group_output"`
+	ErrorReport   string `yaml:"error_report"`
+
+	Tasks []JobTask `yaml:"tasks"`
+
+	dir string // directory of the job file, relative paths are resolved against it
+}
+
+// ModelSpec selects a projection model, empty means inherit it
+type ModelSpec struct {
+	Model       string             `yaml:"model"`
+	ModelParams map[string]float64 `yaml:"model_params"`
+	ModelFile   string             `yaml:"model_file"` // written by the fit command
+}
+
+func (s ModelSpec) isZero() bool {
+	return s.Model == "" && len(s.ModelParams) == 0 && s.ModelFile == ""
+}
+
+// JobTask is one miner of a job, either Epoch or Time may be set
+type JobTask struct {
+	Miner   string `yaml:"miner"`
+	Epoch   *int64 `yaml:"epoch"`
+	Time    string `yaml:"time"`
+	Sectors string `yaml:"sectors"` // ParseSectorNumbers syntax, empty means all sectors
+	Label   string `yaml:"label"`
+
+	ModelSpec `yaml:",inline"`
+}
+
+// jobError reports an invalid field of a job file by its path, e.g. tasks[2].epoch
+type jobError struct {
+	Path string
+	Err  error
+}
+
+func (e *jobError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *jobError) Unwrap() error {
+	return e.Err
+}
+
+// isJobFile reports whether the batch input is a job file rather than CSV
+func isJobFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// loadBatchJob reads a job file, JSON is read as YAML
+func loadBatchJob(filename string) (*BatchJob, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding the node tree ourselves reports unknown fields and wrong types by their path
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid job file %s: %s", filename, yamlLinePrefix.ReplaceAllString(err.Error(), ""))
+	}
+	job := &BatchJob{dir: filepath.Dir(filename)}
+	if len(root.Content) > 0 {
+		if err := decodeJobNode(root.Content[0], reflect.ValueOf(job).Elem(), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if job.OutputFormat != "" {
+		switch job.OutputFormat {
+		case FormatText, FormatJSON, FormatNDJSON, FormatCSV:
+		default:
+			return nil, &jobError{"output_format", fmt.Errorf("unsupported output format: %s", job.OutputFormat)}
+		}
+	}
+	if job.SectorsFormat != "" && job.SectorsFormat != SectorsFormatCSV && job.SectorsFormat != SectorsFormatParquet {
+		return nil, &jobError{"sectors_format", fmt.Errorf("unsupported sectors format: %s", job.SectorsFormat)}
+	}
+	if job.GroupBy != "" {
+		if _, err := parseGroupBy(job.GroupBy); err != nil {
+			return nil, &jobError{"group_by", err}
+		}
+	}
+	if len(job.Tasks) == 0 {
+		return nil, &jobError{"tasks", fmt.Errorf("no tasks")}
+	}
+	return job, nil
+}
+
+// yamlLinePrefix matches the location yaml.v3 puts in front of its messages
+var yamlLinePrefix = regexp.MustCompile(`^yaml: (line \d+: )?`)
+
+// decodeJobNode decodes node into v like yaml.v3 with known fields enforced, but reports
+// errors as a *jobError with the path of the field, e.g. tasks[2].epoch
+func decodeJobNode(node *yaml.Node, v reflect.Value, path string) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeJobNode(node, v.Elem(), path)
+
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return &jobError{jobPath(path), fmt.Errorf("expected a mapping, got %s", describeNode(node))}
+		}
+		fields := make(map[string]reflect.Value)
+		collectJobFields(v, fields)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			field, ok := fields[key]
+			if !ok {
+				return &jobError{joinPath(path, key), fmt.Errorf("unknown field")}
+			}
+			if err := decodeJobNode(node.Content[i+1], field, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return &jobError{jobPath(path), fmt.Errorf("expected a mapping, got %s", describeNode(node))}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeJobNode(node.Content[i+1], elem, joinPath(path, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		return nil
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return &jobError{jobPath(path), fmt.Errorf("expected a list, got %s", describeNode(node))}
+		}
+		v.Set(reflect.MakeSlice(v.Type(), len(node.Content), len(node.Content)))
+		for i, item := range node.Content {
+			if err := decodeJobNode(item, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		if node.Kind != yaml.ScalarNode || node.Decode(v.Addr().Interface()) != nil {
+			return &jobError{jobPath(path), fmt.Errorf("expected %s, got %s", describeKind(v.Kind()), describeNode(node))}
+		}
+		return nil
+	}
+}
+
+// collectJobFields maps the yaml names of the fields of struct v, including inline ones
+func collectJobFields(v reflect.Value, fields map[string]reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		tag, ok := sf.Tag.Lookup("yaml")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "inline" {
+			collectJobFields(v.Field(i), fields)
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jobPath names the document root for errors on it
+func jobPath(path string) string {
+	if path == "" {
+		return "job"
+	}
+	return path
+}
+
+func describeKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	default:
+		return kind.String()
+	}
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	default:
+		return fmt.Sprintf("%q", node.Value)
+	}
+}
+
+// BuildTasks converts the job to batch tasks, times are converted to epochs with genesis.
+// The models used by the tasks are returned by MinerTask.Model, fallback is used for tasks
+// that set no model on their own or through the job.
+func (j *BatchJob) BuildTasks(genesis time.Time, fallback utils.NetworkModel) ([]MinerTask, map[string]utils.NetworkModel, error) {
+	jobModel := modelKey(fallback)
+	models := map[string]utils.NetworkModel{jobModel: fallback}
+
+	if !j.ModelSpec.isZero() {
+		model, err := j.networkModel(j.ModelSpec, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		jobModel = modelKey(model)
+		models[jobModel] = model
+	}
+
+	tasks := make([]MinerTask, 0, len(j.Tasks))
+	for i, jt := range j.Tasks {
+		path := fmt.Sprintf("tasks[%d]", i)
+
+		task := MinerTask{
+			MinerID: strings.TrimSpace(jt.Miner),
+			Sectors: strings.TrimSpace(jt.Sectors),
+			Label:   jt.Label,
+			Model:   jobModel,
+		}
+		if task.MinerID == "" {
+			return nil, nil, &jobError{path + ".miner", fmt.Errorf("missing miner")}
+		}
+
+		switch {
+		case jt.Epoch != nil && jt.Time != "":
+			return nil, nil, &jobError{path, fmt.Errorf("set either epoch or time, not both")}
+		case jt.Time != "":
+			t, err := utils.ParseTime(jt.Time)
+			if err != nil {
+				return nil, nil, &jobError{path + ".time", err}
+			}
+			task.Epoch = utils.TimeToEpoch(t, genesis)
+		case jt.Epoch != nil:
+			if *jt.Epoch < 0 {
+				return nil, nil, &jobError{path + ".epoch", fmt.Errorf("negative epoch %d", *jt.Epoch)}
+			}
+			task.Epoch = abi.ChainEpoch(*jt.Epoch)
+		}
+
+		if task.Sectors != "" {
+			if _, err := utils.ParseSectorNumbers(task.Sectors); err != nil {
+				return nil, nil, &jobError{path + ".sectors", err}
+			}
+		}
+
+		if !jt.ModelSpec.isZero() {
+			model, err := j.networkModel(jt.ModelSpec, path+".")
+			if err != nil {
+				return nil, nil, err
+			}
+			task.Model = modelKey(model)
+			models[task.Model] = model
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, models, nil
+}
+
+// networkModel builds the model of a spec, errors are reported below prefix
+func (j *BatchJob) networkModel(spec ModelSpec, prefix string) (utils.NetworkModel, error) {
+	if spec.ModelFile != "" {
+		if spec.Model != "" || len(spec.ModelParams) > 0 {
+			return nil, &jobError{prefix + "model_file", fmt.Errorf("cannot be combined with model or model_params")}
+		}
+
+		fit, err := utils.LoadModelFit(j.path(spec.ModelFile))
+		if err != nil {
+			return nil, &jobError{prefix + "model_file", err}
+		}
+		model, err := fit.NetworkModel()
+		if err != nil {
+			return nil, &jobError{prefix + "model_file", err}
+		}
+		return model, nil
+	}
+
+	name := spec.Model
+	if name == "" {
+		name = utils.ModelLinear
+	}
+	if !slices.Contains(utils.NetworkModels(), name) {
+		return nil, &jobError{prefix + "model", fmt.Errorf("unknown network model: %s (available: %s)", name, strings.Join(utils.NetworkModels(), ", "))}
+	}
+	model, err := utils.NewNetworkModel(name, spec.ModelParams)
+	if err != nil {
+		return nil, &jobError{prefix + "model_params", err}
+	}
+	return model, nil
+}
+
+// path resolves a file named in the job relative to the job file
+func (j *BatchJob) path(filename string) string {
+	if filename == "" || filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(j.dir, filename)
+}
+
+// modelKey identifies a model by its name and parameters
+func modelKey(model utils.NetworkModel) string {
+	return fmt.Sprintf("%s(%s)", model.Name(), utils.FormatModelParams(model.Params()))
+}
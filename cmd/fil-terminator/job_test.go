@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeJob writes a job file to dir and returns its path
+func writeJob(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0644))
+	return filename
+}
+
+func TestBatchJobModelPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, utils.SaveModelFit(filepath.Join(dir, "fit.json"), utils.ModelFit{
+		Model:  utils.ModelCompound,
+		Params: map[string]float64{utils.ParamPowerGrowthRate: 0.0003},
+	}))
+	filename := writeJob(t, dir, "job.yaml", `
+model: compound
+model_params:
+  power_growth_rate: 0.0002
+tasks:
+  - miner: f01234
+    epoch: 2000000
+  - miner: f01234
+    epoch: 2000000
+    model: frozen
+  - miner: f01234
+    epoch: 2000000
+    model_file: fit.json
+`)
+
+	job, err := loadBatchJob(filename)
+	require.NoError(t, err)
+	cli := utils.FrozenModel{}
+	tasks, models, err := job.BuildTasks(time.Unix(0, 0), cli)
+	require.NoError(t, err)
+	require.Len(t, tasks, 3)
+
+	// The job model replaces the flags, a task model replaces the job model
+	fileModel := models[tasks[0].Model]
+	assert.Equal(t, utils.ModelCompound, fileModel.Name())
+	assert.Equal(t, 0.0002, fileModel.Params()[utils.ParamPowerGrowthRate])
+	assert.Equal(t, utils.ModelFrozen, models[tasks[1].Model].Name())
+	assert.Equal(t, 0.0003, models[tasks[2].Model].Params()[utils.ParamPowerGrowthRate])
+
+	// Without a job model the flags apply
+	filename = writeJob(t, dir, "plain.yaml", "tasks:\n  - miner: f01234\n")
+	job, err = loadBatchJob(filename)
+	require.NoError(t, err)
+	tasks, models, err = job.BuildTasks(time.Unix(0, 0), cli)
+	require.NoError(t, err)
+	assert.Equal(t, cli, models[tasks[0].Model])
+}
+
+func TestBatchJobPaths(t *testing.T) {
+	dir := t.TempDir()
+	job, err := loadBatchJob(writeJob(t, dir, "job.json", `{"output": "out/results.csv", "error_report": "/tmp/errors.json", "tasks": [{"miner": "f01234"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "out", "results.csv"), job.path(job.Output))
+	assert.Equal(t, "/tmp/errors.json", job.path(job.ErrorReport))
+	assert.Equal(t, "", job.path(job.SectorsOutput))
+}
+
+func TestBatchJobOutputPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	filename := writeJob(t, dir, "job.yaml", `
+output: results.ndjson
+output_format: ndjson
+tasks:
+  - miner: f01234
+`)
+	api := fixtureReader(t)
+
+	// Job settings apply relative to the job file
+	require.NoError(t, runApp(t, context.Background(), api, "batch", "--input", filename))
+	data, err := os.ReadFile(filepath.Join(dir, "results.ndjson"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"miner_id":"f01234"`)
+
+	// Flags replace them
+	output := filepath.Join(dir, "flag.csv")
+	require.NoError(t, runApp(t, context.Background(), api, "--output-format", "csv", "batch", "--input", filename, "--output", output))
+	data, err = os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "MinerID,Epoch")
+}
+
+func TestBatchJobErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		path    string
+	}{
+		{
+			name:    "unknown top level field",
+			content: "outputs: results.csv\ntasks:\n  - miner: f01234\n",
+			path:    "outputs",
+		},
+		{
+			name:    "unknown task field",
+			content: "tasks:\n  - miner: f01234\n  - miner: f01234\n    epochs: 100\n",
+			path:    "tasks[1].epochs",
+		},
+		{
+			name:    "wrong scalar type",
+			content: "tasks:\n  - miner: f01234\n  - miner: f01234\n  - miner: f01234\n    epoch: soon\n",
+			path:    "tasks[2].epoch",
+		},
+		{
+			name:    "wrong model parameter type",
+			content: "model_params:\n  power_growth_rate: fast\ntasks:\n  - miner: f01234\n",
+			path:    "model_params.power_growth_rate",
+		},
+		{
+			name:    "tasks not a list",
+			content: "tasks:\n  miner: f01234\n",
+			path:    "tasks",
+		},
+		{
+			name:    "no tasks",
+			content: "output: results.csv\n",
+			path:    "tasks",
+		},
+		{
+			name:    "unsupported output format",
+			content: "output_format: xml\ntasks:\n  - miner: f01234\n",
+			path:    "output_format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadBatchJob(writeJob(t, t.TempDir(), "job.yaml", tt.content))
+			var jobErr *jobError
+			require.ErrorAs(t, err, &jobErr)
+			assert.Equal(t, tt.path, jobErr.Path)
+			assert.NotContains(t, err.Error(), "line")
+		})
+	}
+}
+
+func TestBatchJobTaskErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		task string
+		path string
+	}{
+		{name: "missing miner", task: "epoch: 100", path: "tasks[0].miner"},
+		{name: "epoch and time", task: "miner: f01234\n    epoch: 100\n    time: 2025-01-01", path: "tasks[0]"},
+		{name: "negative epoch", task: "miner: f01234\n    epoch: -1", path: "tasks[0].epoch"},
+		{name: "invalid time", task: "miner: f01234\n    time: someday", path: "tasks[0].time"},
+		{name: "invalid sectors", task: "miner: f01234\n    sectors: 5-1", path: "tasks[0].sectors"},
+		{name: "unknown model", task: "miner: f01234\n    model: quadratic", path: "tasks[0].model"},
+		{name: "invalid model parameter", task: "miner: f01234\n    model_params:\n      min_reward_factor: 5", path: "tasks[0].model_params"},
+		{name: "model file with model", task: "miner: f01234\n    model: linear\n    model_file: fit.json", path: "tasks[0].model_file"},
+		{name: "missing model file", task: "miner: f01234\n    model_file: missing.json", path: "tasks[0].model_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := loadBatchJob(writeJob(t, t.TempDir(), "job.yaml", "tasks:\n  - "+tt.task+"\n"))
+			require.NoError(t, err)
+			_, _, err = job.BuildTasks(time.Unix(0, 0), utils.DefaultNetworkModel())
+			var jobErr *jobError
+			require.ErrorAs(t, err, &jobErr)
+			assert.Equal(t, tt.path, jobErr.Path)
+		})
+	}
+}
+
+func TestBatchJobSyntaxError(t *testing.T) {
+	_, err := loadBatchJob(writeJob(t, t.TempDir(), "job.json", `{"tasks": [`))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "line")
+
+	job, err := loadBatchJob(writeJob(t, t.TempDir(), "job.json", `{"tasks": [{"miner": "f01234", "epoch": 100}]}`))
+	require.NoError(t, err)
+	tasks, _, err := job.BuildTasks(time.Unix(0, 0), utils.DefaultNetworkModel())
+	require.NoError(t, err)
+	assert.Equal(t, abi.ChainEpoch(100), tasks[0].Epoch)
+}
@@ -14,10 +14,18 @@ func main() {
 		Name:                 "fil-terminator",
 		Usage:                "Filecoin miner sector termination fee calculation tool",
 		EnableBashCompletion: true,
-		Version:              fmt.Sprintf("%s+lotus-%s", version.CurrentCommit, build.NodeBuildVersion),
+		Flags: []cli.Flag{
+			outputFormatFlag,
+		},
+		Version: fmt.Sprintf("%s+lotus-%s", version.CurrentCommit, build.NodeBuildVersion),
 		Commands: []*cli.Command{
 			calCmd,
 			batchCmd,
+			timelineCmd,
+			fitCmd,
+			expirationCmd,
+			optimizeCmd,
+			terminateCmd,
 			toolsCmd,
 		},
 	}
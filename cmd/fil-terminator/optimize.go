@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	gobig "math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var optimizeCmd = &cli.Command{
+	Name:    "optimize",
+	Aliases: []string{"best-epoch"},
+	Usage:   "Find the epoch where holding and then terminating a sector set costs the least",
+	Description: "Search the epochs from the current height up to the horizon for the lowest total cost of the selected\n" +
+		"sectors under the projection model: the termination fee at that epoch plus the cost of holding the\n" +
+		"sectors until then. Held proven, the expected block rewards lower the cost (operating costs are not\n" +
+		"included); held unproven, the continued fault fees add to it and the search ends at the fault max age.\n" +
+		"Sectors expired by an epoch cost no fee there. The cost is evaluated every step epochs and at every\n" +
+		"sector expiration, then around the best epoch with finer steps.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "miner",
+			Aliases:  []string{"m"},
+			Usage:    "Miner address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "sectors",
+			Aliases: []string{"s"},
+			Usage:   "Sector number list, comma separated (e.g. 1,2,3 or 1-10)",
+		},
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Calculate all sectors",
+		},
+		&cli.Int64Flag{
+			Name:     "horizon",
+			Usage:    "Search up to this many epochs after the current height",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "step",
+			Usage: "Interval between evaluated epochs before refining",
+			Value: 2880,
+		},
+		&cli.StringFlag{
+			Name:  "hold",
+			Usage: "How the sectors are held until terminated (" + strings.Join(utils.HoldModes(), ", ") + ")",
+			Value: string(utils.HoldProven),
+		},
+		modelFlag,
+		modelParamsFlag,
+		modelFileFlag,
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
+	},
+	Action: optimize,
+}
+
+// OptimumOutput is the machine readable form of a utils.TerminationOptimum. Amounts are
+// given in attoFIL with full precision, the *_fil fields repeat them as FIL strings.
+type OptimumOutput struct {
+	MinerID       string         `json:"miner_id"`
+	CurrentEpoch  abi.ChainEpoch `json:"current_epoch"`
+	Horizon       abi.ChainEpoch `json:"horizon"` // epochs searched, at most the fault max age when held unproven
+	Model         string         `json:"model"`   // projection model after the current epoch, with its parameters
+	Hold          string         `json:"hold"`    // proven or unproven
+	Evaluated     int            `json:"evaluated"`
+	BestEpoch     abi.ChainEpoch `json:"best_epoch"`
+	BestSectors   int            `json:"best_sectors"` // sectors left to terminate at the best epoch
+	BestFee       string         `json:"best_fee"`
+	BestFeeFIL    string         `json:"best_fee_fil"`
+	Holding       string         `json:"holding"` // holding cost until the best epoch, negative for rewards earned
+	HoldingFIL    string         `json:"holding_fil"`
+	BestCost      string         `json:"best_cost"` // best_fee + holding
+	BestCostFIL   string         `json:"best_cost_fil"`
+	TodaySectors  int            `json:"today_sectors"`
+	TodayFee      string         `json:"today_fee"` // also the cost of terminating today
+	TodayFeeFIL   string         `json:"today_fee_fil"`
+	Saving        string         `json:"saving"` // today_fee - best_cost
+	SavingFIL     string         `json:"saving_fil"`
+	SavingPercent *float64       `json:"saving_percent,omitempty"` // omitted if today's fee is zero
+}
+
+func newOptimumOutput(opt utils.TerminationOptimum, model utils.NetworkModel) OptimumOutput {
+	saving := big.Sub(opt.Today.TotalFee, opt.BestCost)
+	out := OptimumOutput{
+		MinerID:      opt.Today.MinerID,
+		CurrentEpoch: opt.Today.TargetEpoch,
+		Horizon:      opt.End - opt.Today.TargetEpoch,
+		Hold:         string(opt.Hold),
+		Evaluated:    opt.Evaluated,
+		BestEpoch:    opt.Best.TargetEpoch,
+		BestSectors:  opt.Best.ActiveSectors,
+		BestFee:      bigString(opt.Best.TotalFee),
+		BestFeeFIL:   types.FIL(opt.Best.TotalFee).String(),
+		Holding:      bigString(opt.Holding),
+		HoldingFIL:   types.FIL(opt.Holding).String(),
+		BestCost:     bigString(opt.BestCost),
+		BestCostFIL:  types.FIL(opt.BestCost).String(),
+		TodaySectors: opt.Today.ActiveSectors,
+		TodayFee:     bigString(opt.Today.TotalFee),
+		TodayFeeFIL:  types.FIL(opt.Today.TotalFee).String(),
+		Saving:       bigString(saving),
+		SavingFIL:    types.FIL(saving).String(),
+	}
+	if model != nil {
+		out.Model = utils.ProjectionInfo{Model: model.Name(), Params: model.Params()}.String()
+	}
+	if opt.Today.TotalFee.Sign() > 0 {
+		ratio := new(gobig.Rat).SetFrac(saving.Int, opt.Today.TotalFee.Int)
+		percent, _ := ratio.Mul(ratio, gobig.NewRat(100, 1)).Float64()
+		out.SavingPercent = &percent
+	}
+	return out
+}
+
+func optimize(c *cli.Context) error {
+	format, err := getOutputFormat(c)
+	if err != nil {
+		return err
+	}
+
+	horizon := abi.ChainEpoch(c.Int64("horizon"))
+	step := abi.ChainEpoch(c.Int64("step"))
+	if horizon <= 0 {
+		return fmt.Errorf("horizon must be positive")
+	}
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	hold := utils.HoldMode(c.String("hold"))
+	if !slices.Contains(utils.HoldModes(), string(hold)) {
+		return fmt.Errorf("unknown hold mode: %s (available: %s)", hold, strings.Join(utils.HoldModes(), ", "))
+	}
+
+	sectorNumbers, err := getSectorSelection(c)
+	if err != nil {
+		return err
+	}
+
+	model, err := getNetworkModel(c)
+	if err != nil {
+		return fmt.Errorf("invalid projection model: %w", err)
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	snap, err := utils.LoadSnapshot(ctx, api, c.String("miner"), 0, sectorNumbers)
+	if err != nil {
+		return err
+	}
+	opt, err := snap.FindBestTerminationEpoch(horizon, step, model, hold)
+	if err != nil {
+		return err
+	}
+
+	out := newOptimumOutput(opt, model)
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		enc := json.NewEncoder(os.Stdout)
+		if format == FormatJSON {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(out)
+	case FormatCSV:
+		return writeOptimumCSV(os.Stdout, out)
+	}
+
+	printOptimum(os.Stdout, opt, out)
+	return nil
+}
+
+func writeOptimumCSV(w io.Writer, out OptimumOutput) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := []string{"MinerID", "CurrentEpoch", "Hold", "BestEpoch", "BestSectors", "BestFee(FIL)", "Holding(FIL)", "BestCost(FIL)", "TodaySectors", "TodayFee(FIL)", "Saving(FIL)", "SavingPercent"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	percent := ""
+	if out.SavingPercent != nil {
+		percent = fmt.Sprintf("%.4f", *out.SavingPercent)
+	}
+	return writer.Write([]string{
+		out.MinerID,
+		fmt.Sprintf("%d", out.CurrentEpoch),
+		out.Hold,
+		fmt.Sprintf("%d", out.BestEpoch),
+		fmt.Sprintf("%d", out.BestSectors),
+		out.BestFeeFIL,
+		out.HoldingFIL,
+		out.BestCostFIL,
+		fmt.Sprintf("%d", out.TodaySectors),
+		out.TodayFeeFIL,
+		out.SavingFIL,
+		percent,
+	})
+}
+
+func printOptimum(w io.Writer, opt utils.TerminationOptimum, out OptimumOutput) {
+	fmt.Fprintf(w, "Searched epochs %d to %d (%d evaluated), sectors held %s\n", out.CurrentEpoch, out.CurrentEpoch+out.Horizon, out.Evaluated, out.Hold)
+	if out.Model != "" {
+		fmt.Fprintf(w, "Projection model: %s\n", out.Model)
+	}
+	fmt.Fprintf(w, "Terminate today (epoch %d): %s, %d sectors\n", out.CurrentEpoch, types.FIL(opt.Today.TotalFee), out.TodaySectors)
+
+	if out.BestEpoch == out.CurrentEpoch {
+		fmt.Fprintf(w, "Best epoch: today, holding the sectors within the horizon does not lower the cost\n")
+		return
+	}
+	fmt.Fprintf(w, "Best epoch: %d (+%.1f days): %s total cost, %d sectors\n",
+		out.BestEpoch, utils.EpochsToDays(out.BestEpoch-out.CurrentEpoch), types.FIL(opt.BestCost), out.BestSectors)
+	fmt.Fprintf(w, "  termination fee %s\n", types.FIL(opt.Best.TotalFee))
+	switch opt.Hold {
+	case utils.HoldProven:
+		fmt.Fprintf(w, "  block rewards until then %s\n", types.FIL(big.Sub(big.Zero(), opt.Holding)))
+	case utils.HoldUnproven:
+		fmt.Fprintf(w, "  fault fees until then %s\n", types.FIL(opt.Holding))
+	}
+	if expired := out.TodaySectors - out.BestSectors; expired > 0 {
+		fmt.Fprintf(w, "  %d sectors expire before then\n", expired)
+	}
+	saving := fmt.Sprintf("Saving: %s", types.FIL(big.Sub(opt.Today.TotalFee, opt.BestCost)))
+	if out.SavingPercent != nil {
+		saving += fmt.Sprintf(" (%.2f%%)", *out.SavingPercent)
+	}
+	fmt.Fprintln(w, saving)
+}
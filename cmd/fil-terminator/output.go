@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// Output formats of calc and batch
+const (
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+var outputFormatFlag = &cli.StringFlag{
+	Name:  "output-format",
+	Usage: "Output format of calc, batch, timeline, expiration and optimize (text, json, ndjson, csv)",
+	Value: FormatText,
+}
+
+// getOutputFormat returns the format selected by the global --output-format
+func getOutputFormat(c *cli.Context) (string, error) {
+	switch format := c.String("output-format"); format {
+	case FormatText, FormatJSON, FormatNDJSON, FormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// writeRecords writes records in a machine readable format: an indented JSON array, one
+// JSON object per line, or CSV with header and row giving the fields of a record
+func writeRecords[T any](w io.Writer, format string, records []T, header []string, row func(T) []string) error {
+	switch format {
+	case FormatJSON:
+		if records == nil {
+			records = []T{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := writer.Write(row(r)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// CalculationOutput is the machine readable form of a utils.CalculationResult. Amounts are
+// given in attoFIL with full precision, the *_fil fields repeat them as FIL strings.
+type CalculationOutput struct {
+	MinerID        string            `json:"miner_id"`
+	TargetEpoch    abi.ChainEpoch    `json:"target_epoch"`
+	CurrentEpoch   abi.ChainEpoch    `json:"current_epoch"`
+	IsEstimate     bool              `json:"is_estimate"`
+	Formula        string            `json:"formula"`
+	Projection     *ProjectionOutput `json:"projection,omitempty"`
+	TotalSectors   int               `json:"total_sectors"`
+	ActiveSectors  int               `json:"active_sectors"`
+	ExpiredSectors int               `json:"expired_sectors"`
+	TotalFee       string            `json:"total_fee"`
+	TotalFeeFIL    string            `json:"total_fee_fil"`
+	TotalPledge    string            `json:"total_pledge"`
+	TotalPledgeFIL string            `json:"total_pledge_fil"`
+	Sectors        []SectorOutput    `json:"sectors"`
+
+	TerminatedSectors  int                    `json:"terminated_sectors"`  // listed but terminated, not in sectors
+	ApproximateSectors int                    `json:"approximate_sectors"` // sectors with approximate = true
+	StatusTotals       []StatusTotalOutput    `json:"status_totals"`
+	PartitionTotals    []PartitionTotalOutput `json:"partition_totals"`
+}
+
+type StatusTotalOutput struct {
+	Status           utils.SectorStatus `json:"status"`
+	Sectors          int                `json:"sectors"`
+	Fee              string             `json:"fee"`
+	FeeFIL           string             `json:"fee_fil"`
+	InitialPledge    string             `json:"initial_pledge"`
+	InitialPledgeFIL string             `json:"initial_pledge_fil"`
+}
+
+type PartitionTotalOutput struct {
+	Deadline         uint64 `json:"deadline"`
+	Partition        uint64 `json:"partition"`
+	Mutable          bool   `json:"mutable"` // terminations are accepted at current_epoch
+	Sectors          int    `json:"sectors"`
+	Fee              string `json:"fee"`
+	FeeFIL           string `json:"fee_fil"`
+	InitialPledge    string `json:"initial_pledge"`
+	InitialPledgeFIL string `json:"initial_pledge_fil"`
+}
+
+type ProjectionOutput struct {
+	Model  string             `json:"model"`
+	Epochs abi.ChainEpoch     `json:"epochs"`
+	Params map[string]float64 `json:"params"`
+}
+
+type SectorOutput struct {
+	SectorNumber     abi.SectorNumber   `json:"sector_number"`
+	Status           utils.SectorStatus `json:"status"`
+	Deadline         uint64             `json:"deadline"`
+	Partition        uint64             `json:"partition"`
+	IsExpired        bool               `json:"is_expired"`
+	ExpiredDays      float64            `json:"expired_days,omitempty"`
+	Age              abi.ChainEpoch     `json:"age"` // epochs
+	Activation       abi.ChainEpoch     `json:"activation"`
+	Expiration       abi.ChainEpoch     `json:"expiration"`
+	Fee              string             `json:"fee"`
+	FeeFIL           string             `json:"fee_fil"`
+	FaultFee         string             `json:"fault_fee"`
+	FaultFeeFIL      string             `json:"fault_fee_fil"`
+	InitialPledge    string             `json:"initial_pledge"`
+	InitialPledgeFIL string             `json:"initial_pledge_fil"`
+	QAPower          string             `json:"qa_power"` // bytes
+	BindingTerm      string             `json:"binding_term,omitempty"`
+	Approximate      bool               `json:"approximate,omitempty"` // fee may be understated, see utils.SectorResult
+}
+
+func newCalculationOutput(result utils.CalculationResult) CalculationOutput {
+	out := CalculationOutput{
+		MinerID:        result.MinerID,
+		TargetEpoch:    result.TargetEpoch,
+		CurrentEpoch:   result.CurrentEpoch,
+		IsEstimate:     result.IsEstimate,
+		Formula:        result.Formula,
+		TotalSectors:   result.TotalSectors,
+		ActiveSectors:  result.ActiveSectors,
+		ExpiredSectors: result.ExpiredSectors,
+		TotalFee:       bigString(result.TotalFee),
+		TotalFeeFIL:    types.FIL(result.TotalFee).String(),
+		TotalPledge:    bigString(result.TotalPledge),
+		TotalPledgeFIL: types.FIL(result.TotalPledge).String(),
+		Sectors:        make([]SectorOutput, 0, len(result.SectorResults)),
+
+		TerminatedSectors:  result.TerminatedSectors,
+		ApproximateSectors: result.ApproximateSectors,
+		StatusTotals:       make([]StatusTotalOutput, 0, len(result.StatusTotals)),
+		PartitionTotals:    make([]PartitionTotalOutput, 0, len(result.PartitionTotals)),
+	}
+	if p := result.Projection; p != nil {
+		out.Projection = &ProjectionOutput{Model: p.Model, Epochs: p.Epochs, Params: p.Params}
+	}
+
+	for _, s := range result.SectorResults {
+		out.Sectors = append(out.Sectors, SectorOutput{
+			SectorNumber:     s.SectorNumber,
+			Status:           s.Status,
+			Deadline:         s.Deadline,
+			Partition:        s.Partition,
+			IsExpired:        s.IsExpired,
+			ExpiredDays:      s.ExpiredDays,
+			Age:              s.Age,
+			Activation:       s.Activation,
+			Expiration:       s.Expiration,
+			Fee:              bigString(s.Fee),
+			FeeFIL:           types.FIL(s.Fee).String(),
+			FaultFee:         bigString(s.FaultFee),
+			FaultFeeFIL:      types.FIL(s.FaultFee).String(),
+			InitialPledge:    bigString(s.InitialPledge),
+			InitialPledgeFIL: types.FIL(s.InitialPledge).String(),
+			QAPower:          bigString(s.QAPower),
+			BindingTerm:      s.BindingTerm,
+			Approximate:      s.Approximate,
+		})
+	}
+
+	for _, st := range result.StatusTotals {
+		out.StatusTotals = append(out.StatusTotals, StatusTotalOutput{
+			Status:           st.Status,
+			Sectors:          st.Sectors,
+			Fee:              bigString(st.Fee),
+			FeeFIL:           types.FIL(st.Fee).String(),
+			InitialPledge:    bigString(st.InitialPledge),
+			InitialPledgeFIL: types.FIL(st.InitialPledge).String(),
+		})
+	}
+
+	for _, pt := range result.PartitionTotals {
+		out.PartitionTotals = append(out.PartitionTotals, PartitionTotalOutput{
+			Deadline:         pt.Deadline,
+			Partition:        pt.Partition,
+			Mutable:          pt.Mutable,
+			Sectors:          pt.Sectors,
+			Fee:              bigString(pt.Fee),
+			FeeFIL:           types.FIL(pt.Fee).String(),
+			InitialPledge:    bigString(pt.InitialPledge),
+			InitialPledgeFIL: types.FIL(pt.InitialPledge).String(),
+		})
+	}
+	return out
+}
+
+// bigString formats v with full precision, nil values of failed results are zero
+func bigString(v big.Int) string {
+	if v.Int == nil {
+		return "0"
+	}
+	return v.String()
+}
+
+// writeCalculationSectorsCSV writes one row per sector of a calculation
+func writeCalculationSectorsCSV(w io.Writer, out CalculationOutput) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := []string{"MinerID", "Epoch", "Sector", "Expired", "Age", "Fee(attoFIL)", "Fee(FIL)", "FaultFee(attoFIL)", "InitialPledge(attoFIL)", "QAPower", "BindingTerm", "Status", "Deadline", "Partition", "Approximate"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range out.Sectors {
+		record := []string{
+			out.MinerID,
+			fmt.Sprintf("%d", out.TargetEpoch),
+			fmt.Sprintf("%d", s.SectorNumber),
+			fmt.Sprintf("%t", s.IsExpired),
+			fmt.Sprintf("%d", s.Age),
+			s.Fee,
+			s.FeeFIL,
+			s.FaultFee,
+			s.InitialPledge,
+			s.QAPower,
+			s.BindingTerm,
+			string(s.Status),
+			fmt.Sprintf("%d", s.Deadline),
+			fmt.Sprintf("%d", s.Partition),
+			fmt.Sprintf("%t", s.Approximate),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCalculationPartitionsCSV writes one row per deadline and partition of a calculation
+func writeCalculationPartitionsCSV(w io.Writer, out CalculationOutput) error {
+	writer := csv.NewWriter(w)
+	defer writer.Flush()
+
+	header := []string{"MinerID", "Epoch", "Deadline", "Partition", "Mutable", "Sectors", "Fee(attoFIL)", "Fee(FIL)", "InitialPledge(attoFIL)", "InitialPledge(FIL)"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, pt := range out.PartitionTotals {
+		record := []string{
+			out.MinerID,
+			fmt.Sprintf("%d", out.TargetEpoch),
+			fmt.Sprintf("%d", pt.Deadline),
+			fmt.Sprintf("%d", pt.Partition),
+			fmt.Sprintf("%t", pt.Mutable),
+			fmt.Sprintf("%d", pt.Sectors),
+			pt.Fee,
+			pt.FeeFIL,
+			pt.InitialPledge,
+			pt.InitialPledgeFIL,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MinerOutput is the machine readable form of a batch MinerResult
+type MinerOutput struct {
+	MinerID            string         `json:"miner_id"`
+	Epoch              abi.ChainEpoch `json:"epoch"`
+	Status             string         `json:"status"`
+	TotalSectors       int            `json:"total_sectors"`
+	ActiveSectors      int            `json:"active_sectors"`
+	ExpiredSectors     int            `json:"expired_sectors"`
+	TotalFee           string         `json:"total_fee"`
+	TotalFeeFIL        string         `json:"total_fee_fil"`
+	TotalPledge        string         `json:"total_pledge"`
+	TotalPledgeFIL     string         `json:"total_pledge_fil"`
+	BindingTerms       string         `json:"binding_terms,omitempty"`
+	Formula            string         `json:"formula,omitempty"`
+	Projection         string         `json:"projection,omitempty"`
+	Error              string         `json:"error,omitempty"`
+	Sectors            string         `json:"sectors,omitempty"`
+	Label              string         `json:"label,omitempty"`
+	SectorStatuses     string         `json:"sector_statuses,omitempty"`
+	TerminatedSectors  int            `json:"terminated_sectors"`
+	ApproximateSectors int            `json:"approximate_sectors,omitempty"`
+	Owner              string         `json:"owner,omitempty"`
+	Worker             string         `json:"worker,omitempty"`
+	Beneficiary        string         `json:"beneficiary,omitempty"`
+}
+
+func newMinerOutput(result MinerResult) MinerOutput {
+	return MinerOutput{
+		MinerID:            result.MinerID,
+		Epoch:              result.Epoch,
+		Status:             result.Status,
+		TotalSectors:       result.TotalSectors,
+		ActiveSectors:      result.ActiveSectors,
+		ExpiredSectors:     result.ExpiredSectors,
+		TotalFee:           bigString(result.TotalFee),
+		TotalFeeFIL:        types.FIL(result.TotalFee).String(),
+		TotalPledge:        bigString(result.TotalPledge),
+		TotalPledgeFIL:     types.FIL(result.TotalPledge).String(),
+		BindingTerms:       result.BindingTerms,
+		Formula:            result.Formula,
+		Projection:         result.Projection,
+		Error:              result.Error,
+		Sectors:            result.Sectors,
+		Label:              result.Label,
+		SectorStatuses:     result.SectorStatuses,
+		TerminatedSectors:  result.TerminatedSectors,
+		ApproximateSectors: result.ApproximateSectors,
+		Owner:              result.Owner,
+		Worker:             result.Worker,
+		Beneficiary:        result.Beneficiary,
+	}
+}
+
+// resultWriter writes batch results one at a time as they become available
+type resultWriter interface {
+	Write(MinerResult) error
+	// Close finishes the output, it may be called more than once
+	Close() error
+}
+
+// newResultWriter writes results in format to filename, or to stdout if filename is empty
+func newResultWriter(format, filename string) (resultWriter, error) {
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		w = file
+	}
+
+	switch format {
+	case FormatCSV:
+		rw, err := newCSVResultWriter(w)
+		if err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+		return rw, nil
+	case FormatNDJSON:
+		return &ndjsonResultWriter{w: w, enc: json.NewEncoder(w)}, nil
+	case FormatJSON:
+		return &jsonResultWriter{w: w}, nil
+	default:
+		_ = w.Close()
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// csvResultWriter writes batch results as CSV rows, flushing each row
+type csvResultWriter struct {
+	w      io.WriteCloser
+	writer *csv.Writer
+}
+
+func newCSVResultWriter(w io.WriteCloser) (*csvResultWriter, error) {
+	rw := &csvResultWriter{w: w, writer: csv.NewWriter(w)}
+	header := []string{"MinerID", "Epoch", "Status", "TotalSectors", "ActiveSectors", "ExpiredSectors", "TotalFee(FIL)", "Error", "InitialPledge(FIL)", "BindingTerms", "Formula", "Projection", "Sectors", "Label", "Owner", "Worker", "Beneficiary", "SectorStatuses", "TerminatedSectors", "TotalFee(attoFIL)", "InitialPledge(attoFIL)"}
+	if err := rw.writeRecord(header); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func (rw *csvResultWriter) Write(result MinerResult) error {
+	return rw.writeRecord([]string{
+		result.MinerID,
+		fmt.Sprintf("%d", result.Epoch),
+		result.Status,
+		fmt.Sprintf("%d", result.TotalSectors),
+		fmt.Sprintf("%d", result.ActiveSectors),
+		fmt.Sprintf("%d", result.ExpiredSectors),
+		types.FIL(result.TotalFee).String(),
+		result.Error,
+		types.FIL(result.TotalPledge).String(),
+		result.BindingTerms,
+		result.Formula,
+		result.Projection,
+		result.Sectors,
+		result.Label,
+		result.Owner,
+		result.Worker,
+		result.Beneficiary,
+		result.SectorStatuses,
+		fmt.Sprintf("%d", result.TerminatedSectors),
+		bigString(result.TotalFee),
+		bigString(result.TotalPledge),
+	})
+}
+
+func (rw *csvResultWriter) writeRecord(record []string) error {
+	if err := rw.writer.Write(record); err != nil {
+		return err
+	}
+	rw.writer.Flush()
+	return rw.writer.Error()
+}
+
+func (rw *csvResultWriter) Close() error {
+	if rw.w == nil {
+		return nil
+	}
+	err := rw.w.Close()
+	rw.w = nil
+	return err
+}
+
+// ndjsonResultWriter streams one JSON record per line
+type ndjsonResultWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func (rw *ndjsonResultWriter) Write(result MinerResult) error {
+	return rw.enc.Encode(newMinerOutput(result))
+}
+
+func (rw *ndjsonResultWriter) Close() error {
+	if rw.w == nil {
+		return nil
+	}
+	err := rw.w.Close()
+	rw.w = nil
+	return err
+}
+
+// jsonResultWriter collects the results and writes them as one array on Close
+type jsonResultWriter struct {
+	w       io.WriteCloser
+	records []MinerOutput
+}
+
+func (rw *jsonResultWriter) Write(result MinerResult) error {
+	rw.records = append(rw.records, newMinerOutput(result))
+	return nil
+}
+
+func (rw *jsonResultWriter) Close() error {
+	if rw.w == nil {
+		return nil
+	}
+	w := rw.w
+	rw.w = nil
+
+	if rw.records == nil {
+		rw.records = []MinerOutput{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rw.records); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readCSV reads a CSV output file as header and rows
+func readCSV(t *testing.T, filename string) ([]string, [][]string) {
+	t.Helper()
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	return records[0], records[1:]
+}
+
+func TestTimelineOutputFormat(t *testing.T) {
+	dir := t.TempDir()
+	api := fixtureReader(t)
+	timelineArgs := func(output string, args ...string) []string {
+		return append(args, "timeline", "--miner", testMiner, "--all", "--start", "1000000", "--end", "1005760", "--output", filepath.Join(dir, output))
+	}
+
+	require.NoError(t, runApp(t, context.Background(), api, timelineArgs("timeline.ndjson", "--output-format", "ndjson")...))
+	data, err := os.ReadFile(filepath.Join(dir, "timeline.ndjson"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	var point TimelinePoint
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &point))
+	assert.EqualValues(t, 1005760, point.Epoch)
+	assert.True(t, point.IsEstimate)
+
+	require.NoError(t, runApp(t, context.Background(), api, timelineArgs("timeline.csv", "--output-format", "csv")...))
+	header, rows := readCSV(t, filepath.Join(dir, "timeline.csv"))
+	assert.Equal(t, timelineCSVHeader, header)
+	assert.Len(t, rows, 3)
+
+	// The deprecated --format still applies
+	require.NoError(t, runApp(t, context.Background(), api, append(timelineArgs("timeline.json"), "--format", "json")...))
+	data, err = os.ReadFile(filepath.Join(dir, "timeline.json"))
+	require.NoError(t, err)
+	var points []TimelinePoint
+	require.NoError(t, json.Unmarshal(data, &points))
+	assert.Len(t, points, 3)
+}
+
+func TestBatchCSVAttoFIL(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "miners.csv")
+	require.NoError(t, os.WriteFile(input, []byte("miner,epoch,label\nf01234,0,a\nf01234,1200000,a\n"), 0644))
+	output, groupOutput := filepath.Join(dir, "results.csv"), filepath.Join(dir, "groups.csv")
+
+	require.NoError(t, runApp(t, context.Background(), fixtureReader(t), "--output-format", "csv",
+		"batch", "--input", input, "--output", output, "--group-by", "label", "--group-output", groupOutput))
+
+	column := func(header []string, name string) int {
+		i := slices.Index(header, name)
+		require.GreaterOrEqual(t, i, 0, name)
+		return i
+	}
+
+	header, rows := readCSV(t, output)
+	require.Len(t, rows, 2)
+	// Scripts reading the original columns by position keep working, new columns are appended
+	assert.Equal(t, []string{"MinerID", "Epoch", "Status", "TotalSectors", "ActiveSectors", "ExpiredSectors", "TotalFee(FIL)", "Error"}, header[:8])
+	fee := column(header, "TotalFee(attoFIL)")
+	pledge := column(header, "InitialPledge(attoFIL)")
+	for _, row := range rows {
+		assert.NotEqual(t, "0", row[fee])
+		assert.NotEqual(t, "0", row[pledge])
+		assert.NotContains(t, row[fee], "FIL")
+	}
+
+	header, groups := readCSV(t, groupOutput)
+	require.Len(t, groups, 2) // one per epoch
+	for _, group := range groups {
+		assert.NotContains(t, group[column(header, "TotalFee(attoFIL)")], "FIL")
+		assert.NotEqual(t, "0", group[column(header, "InitialPledge(attoFIL)")])
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func processTestTasks(n int) []MinerTask {
+	tasks := make([]MinerTask, n)
+	for i := range tasks {
+		tasks[i] = MinerTask{MinerID: fmt.Sprintf("f0%d", 1000+i)}
+	}
+	return tasks
+}
+
+func TestProcessTasksEmitsInInputOrder(t *testing.T) {
+	tasks := processTestTasks(5)
+
+	// Every task waits for the one after it, so they finish in reverse order
+	done := make(map[string]chan struct{})
+	for _, task := range tasks {
+		done[task.MinerID] = make(chan struct{})
+	}
+	var lk sync.Mutex
+	var finished []string
+	process := func(_ context.Context, task MinerTask) taskOutcome {
+		for i := range tasks[:len(tasks)-1] {
+			if tasks[i].MinerID == task.MinerID {
+				<-done[tasks[i+1].MinerID]
+			}
+		}
+		lk.Lock()
+		finished = append(finished, task.MinerID)
+		lk.Unlock()
+		close(done[task.MinerID])
+		return taskOutcome{result: MinerResult{MinerID: task.MinerID}}
+	}
+
+	var emitted []string
+	processTasks(context.Background(), tasks, len(tasks), process, func(out taskOutcome) {
+		emitted = append(emitted, out.result.MinerID)
+	})
+
+	require.Len(t, finished, len(tasks))
+	assert.Equal(t, tasks[len(tasks)-1].MinerID, finished[0])
+	assert.Equal(t, tasks[0].MinerID, finished[len(finished)-1])
+	for i, task := range tasks {
+		assert.Equal(t, task.MinerID, emitted[i])
+	}
+}
+
+func TestProcessTasksCancelled(t *testing.T) {
+	tasks := processTestTasks(10)
+	emitted := func(ctx context.Context, process func(context.Context, MinerTask) taskOutcome) []string {
+		var ids []string
+		processTasks(ctx, tasks, 1, process, func(out taskOutcome) {
+			ids = append(ids, out.result.MinerID)
+		})
+		return ids
+	}
+
+	// Nothing starts on a cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Empty(t, emitted(ctx, func(context.Context, MinerTask) taskOutcome {
+		t.Fatal("task started after cancel")
+		return taskOutcome{}
+	}))
+
+	// Tasks started before the cancel are emitted, the rest are skipped
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ids := emitted(ctx, func(_ context.Context, task MinerTask) taskOutcome {
+		if task.MinerID == tasks[1].MinerID {
+			cancel()
+		}
+		return taskOutcome{result: MinerResult{MinerID: task.MinerID}}
+	})
+	assert.Equal(t, []string{tasks[0].MinerID, tasks[1].MinerID}, ids)
+}
+
+func TestProcessTasksWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		tasks, workers, maxInFlight int
+	}{
+		{tasks: 6, workers: 2, maxInFlight: 2},
+		{tasks: 3, workers: 100, maxInFlight: 3}, // clamped to the number of tasks
+		{tasks: 3, workers: 0, maxInFlight: 1},   // at least one worker
+		{tasks: 0, workers: 4, maxInFlight: 0},
+	} {
+		t.Run(fmt.Sprintf("%d tasks %d workers", tc.tasks, tc.workers), func(t *testing.T) {
+			tasks := processTestTasks(tc.tasks)
+			goroutines := runtime.NumGoroutine()
+
+			// Tasks wait until maxInFlight of them wait together, or all tasks have started
+			var lk sync.Mutex
+			waiting, maxWaiting, started, maxGoroutines := 0, 0, 0, 0
+			release := make(chan struct{})
+			process := func(_ context.Context, task MinerTask) taskOutcome {
+				lk.Lock()
+				waiting++
+				started++
+				maxWaiting = max(maxWaiting, waiting)
+				maxGoroutines = max(maxGoroutines, runtime.NumGoroutine())
+				wait := release
+				if waiting == tc.maxInFlight || started == tc.tasks {
+					close(release)
+					release = make(chan struct{})
+					waiting = 0
+				}
+				lk.Unlock()
+				<-wait
+				return taskOutcome{result: MinerResult{MinerID: task.MinerID}}
+			}
+
+			emitted := 0
+			processTasks(context.Background(), tasks, tc.workers, process, func(taskOutcome) { emitted++ })
+			assert.Equal(t, tc.tasks, emitted)
+			assert.Equal(t, tc.maxInFlight, maxWaiting)
+			// Workers, the feeder and the closer, no idle workers beyond the tasks
+			assert.LessOrEqual(t, maxGoroutines, goroutines+tc.maxInFlight+2)
+		})
+	}
+}
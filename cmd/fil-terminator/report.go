@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes of batch, other commands exit with 1 on any error
+const (
+	ExitOK             = 0
+	ExitError          = 1 // the run itself failed, e.g. the node or output is unavailable
+	ExitInvalidInput   = 2 // input, job file or flags are invalid, nothing was calculated
+	ExitPartialFailure = 3 // some tasks failed
+	ExitAllFailed      = 4 // every task failed
+	ExitAborted        = 5 // --strict stopped the run at a failure, some tasks were not attempted
+)
+
+// invalidInput marks err as an input error, see ExitInvalidInput
+func invalidInput(err error) error {
+	return cli.Exit(err, ExitInvalidInput)
+}
+
+// failureExit returns the exit error of a batch run of total tasks where done were attempted
+// and failed of them failed, nil if none failed
+func failureExit(total, done, failed int, strictFailure *MinerResult) error {
+	code := ExitPartialFailure
+	switch {
+	case done < total:
+		code = ExitAborted
+	case failed == total:
+		code = ExitAllFailed
+	}
+
+	switch {
+	case strictFailure != nil:
+		return cli.Exit(fmt.Sprintf("strict mode: aborted after miner %s at epoch %d failed: %s", strictFailure.MinerID, strictFailure.Epoch, strictFailure.Error), code)
+	case failed == 0:
+		return nil
+	case failed == total:
+		return cli.Exit(fmt.Sprintf("all %d tasks failed", total), code)
+	default:
+		return cli.Exit(fmt.Sprintf("%d of %d tasks failed", failed, total), code)
+	}
+}
+
+// ErrorReport lists the failed tasks of a batch run
+type ErrorReport struct {
+	Tasks   int               `json:"tasks"` // tasks in the input
+	Done    int               `json:"done"`  // tasks finished before the run ended
+	Failed  int               `json:"failed"`
+	Aborted bool              `json:"aborted"` // stopped early by --strict
+	Errors  []TaskErrorOutput `json:"errors"`
+}
+
+// TaskErrorOutput is one failed task, Category is utils.ErrorCategory of the cause
+type TaskErrorOutput struct {
+	MinerID  string         `json:"miner_id"`
+	Epoch    abi.ChainEpoch `json:"epoch"`
+	Sectors  string         `json:"sectors,omitempty"`
+	Label    string         `json:"label,omitempty"`
+	Category string         `json:"category"`
+	Stage    utils.Stage    `json:"stage,omitempty"`
+	Error    string         `json:"error"`
+}
+
+func newErrorReport(tasks int, results []MinerResult, aborted bool) ErrorReport {
+	report := ErrorReport{Tasks: tasks, Done: len(results), Aborted: aborted, Errors: []TaskErrorOutput{}}
+	for _, r := range results {
+		if r.Error == "" {
+			continue
+		}
+		out := TaskErrorOutput{
+			MinerID:  r.MinerID,
+			Epoch:    r.Epoch,
+			Sectors:  r.Sectors,
+			Label:    r.Label,
+			Category: utils.ErrorCategory(r.Err),
+			Error:    r.Error,
+		}
+		var calcErr *utils.CalculationError
+		if errors.As(r.Err, &calcErr) {
+			out.Stage = calcErr.Stage
+		}
+		report.Errors = append(report.Errors, out)
+	}
+	report.Failed = len(report.Errors)
+	return report
+}
+
+// writeErrorReport writes the report as JSON to filename
+func writeErrorReport(filename string, report ErrorReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestFailureExit(t *testing.T) {
+	failure := &MinerResult{MinerID: testMiner, Epoch: 100, Error: "boom"}
+
+	tests := []struct {
+		name                string
+		total, done, failed int
+		strict              *MinerResult
+		code                int
+	}{
+		{name: "no failures", total: 3, done: 3, code: ExitOK},
+		{name: "some failed", total: 3, done: 3, failed: 1, code: ExitPartialFailure},
+		{name: "all failed", total: 3, done: 3, failed: 3, code: ExitAllFailed},
+		{name: "strict abort at the first task", total: 3, done: 1, failed: 1, strict: failure, code: ExitAborted},
+		{name: "strict abort after successes", total: 3, done: 2, failed: 1, strict: failure, code: ExitAborted},
+		{name: "strict failure at the last task", total: 3, done: 3, failed: 1, strict: failure, code: ExitPartialFailure},
+		{name: "strict with every task failed", total: 1, done: 1, failed: 1, strict: failure, code: ExitAllFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := failureExit(tt.total, tt.done, tt.failed, tt.strict)
+			if tt.code == ExitOK {
+				assert.NoError(t, err)
+				return
+			}
+			var exit cli.ExitCoder
+			require.ErrorAs(t, err, &exit)
+			assert.Equal(t, tt.code, exit.ExitCode())
+		})
+	}
+}
+
+func TestBatchStrictAbort(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "miners.csv")
+	require.NoError(t, os.WriteFile(input, []byte("miner,epoch\nnot-a-miner,0\nf01234,0\nf01234,1200000\n"), 0644))
+
+	err := runApp(t, context.Background(), fixtureReader(t), "batch", "--input", input, "--output", filepath.Join(dir, "results.csv"), "--strict", "-j", "1")
+	var exit cli.ExitCoder
+	require.ErrorAs(t, err, &exit)
+	assert.Equal(t, ExitAborted, exit.ExitCode())
+	assert.Contains(t, err.Error(), "strict mode: aborted after miner not-a-miner")
+}
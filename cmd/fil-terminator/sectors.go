@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Formats of the per-sector batch export
+const (
+	SectorsFormatCSV     = "csv"
+	SectorsFormatParquet = "parquet"
+)
+
+// SectorRow is one (miner, epoch, sector) row of the per-sector export. Amounts are attoFIL
+// strings to keep full precision, the *FIL fields repeat them in FIL.
+type SectorRow struct {
+	MinerID          string `parquet:"name=miner_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Label            string `parquet:"name=label, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Epoch            int64  `parquet:"name=epoch, type=INT64"`
+	SectorNumber     int64  `parquet:"name=sector_number, type=INT64"`
+	Status           string `parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IsExpired        bool   `parquet:"name=is_expired, type=BOOLEAN"`
+	Age              int64  `parquet:"name=age, type=INT64"`
+	Activation       int64  `parquet:"name=activation, type=INT64"`
+	Expiration       int64  `parquet:"name=expiration, type=INT64"`
+	Fee              string `parquet:"name=fee, type=BYTE_ARRAY, convertedtype=UTF8"`
+	FeeFIL           string `parquet:"name=fee_fil, type=BYTE_ARRAY, convertedtype=UTF8"`
+	InitialPledge    string `parquet:"name=initial_pledge, type=BYTE_ARRAY, convertedtype=UTF8"`
+	InitialPledgeFIL string `parquet:"name=initial_pledge_fil, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Deadline         int64  `parquet:"name=deadline, type=INT64"`
+	Partition        int64  `parquet:"name=partition, type=INT64"`
+}
+
+// newSectorRows returns the rows of all sectors of a calculation
+func newSectorRows(result utils.CalculationResult, label string) []SectorRow {
+	rows := make([]SectorRow, 0, len(result.SectorResults))
+	for _, s := range result.SectorResults {
+		rows = append(rows, SectorRow{
+			MinerID:          result.MinerID,
+			Label:            label,
+			Epoch:            int64(result.TargetEpoch),
+			SectorNumber:     int64(s.SectorNumber),
+			Status:           string(s.Status),
+			IsExpired:        s.IsExpired,
+			Age:              int64(s.Age),
+			Activation:       int64(s.Activation),
+			Expiration:       int64(s.Expiration),
+			Fee:              bigString(s.Fee),
+			FeeFIL:           types.FIL(s.Fee).String(),
+			InitialPledge:    bigString(s.InitialPledge),
+			InitialPledgeFIL: types.FIL(s.InitialPledge).String(),
+			Deadline:         int64(s.Deadline),
+			Partition:        int64(s.Partition),
+		})
+	}
+	return rows
+}
+
+// sectorWriter writes the sectors of each calculation to the per-sector export
+type sectorWriter interface {
+	Write(result utils.CalculationResult, label string) error
+	// Close finishes the file, it may be called more than once
+	Close() error
+}
+
+// newSectorWriter creates the per-sector export at filename in the given format
+func newSectorWriter(format, filename string) (sectorWriter, error) {
+	switch format {
+	case SectorsFormatCSV:
+		return newCSVSectorWriter(filename)
+	case SectorsFormatParquet:
+		return newParquetSectorWriter(filename)
+	default:
+		return nil, fmt.Errorf("unsupported sectors format: %s", format)
+	}
+}
+
+type csvSectorWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func newCSVSectorWriter(filename string) (*csvSectorWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &csvSectorWriter{file: file, writer: csv.NewWriter(file)}
+	header := []string{"MinerID", "Epoch", "Sector", "Expired", "Age", "Activation", "Expiration", "Fee(attoFIL)", "Fee(FIL)", "InitialPledge(attoFIL)", "InitialPledge(FIL)", "Label", "Status", "Deadline", "Partition"}
+	if err := w.writer.Write(header); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvSectorWriter) Write(result utils.CalculationResult, label string) error {
+	for _, row := range newSectorRows(result, label) {
+		record := []string{
+			row.MinerID,
+			fmt.Sprintf("%d", row.Epoch),
+			fmt.Sprintf("%d", row.SectorNumber),
+			fmt.Sprintf("%t", row.IsExpired),
+			fmt.Sprintf("%d", row.Age),
+			fmt.Sprintf("%d", row.Activation),
+			fmt.Sprintf("%d", row.Expiration),
+			row.Fee,
+			row.FeeFIL,
+			row.InitialPledge,
+			row.InitialPledgeFIL,
+			row.Label,
+			row.Status,
+			fmt.Sprintf("%d", row.Deadline),
+			fmt.Sprintf("%d", row.Partition),
+		}
+		if err := w.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvSectorWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	w.writer.Flush()
+	err := w.writer.Error()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// parquetSectorWriter writes snappy compressed row groups, the file is only readable
+// once closed
+type parquetSectorWriter struct {
+	file   source.ParquetFile
+	writer *writer.ParquetWriter
+}
+
+func newParquetSectorWriter(filename string) (*parquetSectorWriter, error) {
+	file, err := local.NewLocalFileWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewParquetWriter(file, new(SectorRow), 4)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetSectorWriter{file: file, writer: pw}, nil
+}
+
+func (w *parquetSectorWriter) Write(result utils.CalculationResult, label string) error {
+	for _, row := range newSectorRows(result, label) {
+		if err := w.writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *parquetSectorWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.writer.WriteStop()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// sectorsTestResult has an amount beyond int64 and an expired sector without a fee
+func sectorsTestResult(t *testing.T) utils.CalculationResult {
+	large, err := big.FromString("123456789012345678901234567890")
+	require.NoError(t, err)
+	return utils.CalculationResult{
+		MinerID:     testMiner,
+		TargetEpoch: 1000000,
+		SectorResults: []utils.SectorResult{
+			{
+				SectorNumber:  1,
+				Status:        utils.SectorActive,
+				Age:           500000,
+				Activation:    500000,
+				Expiration:    1500000,
+				Fee:           large,
+				InitialPledge: big.NewInt(2e18),
+				Deadline:      5,
+				Partition:     1,
+			},
+			{
+				SectorNumber:  abi.SectorNumber(2),
+				Status:        utils.SectorActive,
+				IsExpired:     true,
+				Activation:    100000,
+				Expiration:    900000,
+				InitialPledge: big.NewInt(1),
+			},
+		},
+	}
+}
+
+func TestParquetSectorWriterRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sectors.parquet")
+	w, err := newSectorWriter(SectorsFormatParquet, filename)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(sectorsTestResult(t), "pool"))
+	require.NoError(t, w.Write(sectorsTestResult(t), ""))
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+
+	file, err := local.NewLocalFileReader(filename)
+	require.NoError(t, err)
+	defer file.Close()
+	pr, err := reader.NewParquetReader(file, new(SectorRow), 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+
+	// All columns are required, amounts are UTF8 strings
+	var names []string
+	for i, el := range pr.Footer.Schema[1:] {
+		names = append(names, pr.SchemaHandler.Infos[i+1].ExName)
+		assert.Equal(t, parquet.FieldRepetitionType_REQUIRED, el.GetRepetitionType(), el.GetName())
+		if el.GetType() == parquet.Type_BYTE_ARRAY {
+			assert.Equal(t, parquet.ConvertedType_UTF8, el.GetConvertedType(), el.GetName())
+		}
+	}
+	assert.Equal(t, []string{"miner_id", "label", "epoch", "sector_number", "status", "is_expired", "age", "activation",
+		"expiration", "fee", "fee_fil", "initial_pledge", "initial_pledge_fil", "deadline", "partition"}, names)
+
+	require.EqualValues(t, 4, pr.GetNumRows())
+	rows := make([]SectorRow, 4)
+	require.NoError(t, pr.Read(&rows))
+
+	assert.Equal(t, newSectorRows(sectorsTestResult(t), "pool"), rows[:2])
+	assert.Equal(t, newSectorRows(sectorsTestResult(t), ""), rows[2:])
+
+	// Full attoFIL precision, a missing fee reads back as zero and an empty label as empty
+	assert.Equal(t, "123456789012345678901234567890", rows[0].Fee)
+	assert.Equal(t, "123456789012.34567890123456789 FIL", rows[0].FeeFIL)
+	assert.Equal(t, "0", rows[1].Fee)
+	assert.Equal(t, "0 FIL", rows[1].FeeFIL)
+	assert.True(t, rows[1].IsExpired)
+	assert.Empty(t, rows[2].Label)
+}
+
+func TestCSVSectorWriterRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "sectors.csv")
+	w, err := newSectorWriter(SectorsFormatCSV, filename)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(sectorsTestResult(t), "pool"))
+	require.NoError(t, w.Close())
+
+	header, rows := readCSV(t, filename)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "Fee(attoFIL)", header[7])
+	assert.Equal(t, []string{testMiner, "1000000", "1", "false", "500000", "500000", "1500000",
+		"123456789012345678901234567890", "123456789012.34567890123456789 FIL", "2000000000000000000", "2 FIL",
+		"pool", "active", "5", "1"}, rows[0])
+	assert.Equal(t, "0", rows[1][7])
+	assert.Equal(t, "true", rows[1][3])
+}
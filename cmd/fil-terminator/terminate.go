@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Formats of the unsigned messages
+const (
+	MessagesFormatJSON = "json"
+	MessagesFormatCBOR = "cbor"
+)
+
+var terminateCmd = &cli.Command{
+	Name:    "terminate-messages",
+	Aliases: []string{"tmsg"},
+	Usage:   "Build unsigned TerminateSectors messages for offline signing",
+	Description: "Group the selected sectors into TerminateSectors messages by deadline and partition, within the\n" +
+		"per-message limits of the network, and write them unsigned with the estimated fee of each message.\n" +
+		"Nothing is signed or pushed; nonce and gas are left to the signer unless set with the flags below.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "miner",
+			Aliases:  []string{"m"},
+			Usage:    "Miner address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "sectors",
+			Aliases: []string{"s"},
+			Usage:   "Sector number list, comma separated (e.g. 1,2,3 or 1-10)",
+		},
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Terminate all sectors",
+		},
+		&cli.Uint64Flag{
+			Name:  "deadline",
+			Usage: "Only terminate the sectors assigned to this deadline (0-47)",
+		},
+		&cli.Uint64Flag{
+			Name:  "partition",
+			Usage: "Only terminate the sectors of this partition of --deadline",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "Sender of the messages, the owner, worker or a control address of the miner (default: worker)",
+		},
+		&cli.Uint64Flag{
+			Name:  "nonce",
+			Usage: "Nonce of the first message, incremented for each following message",
+		},
+		&cli.Int64Flag{
+			Name:  "gas-limit",
+			Usage: "Gas limit of each message, 0 leaves it to the signer",
+		},
+		&cli.StringFlag{
+			Name:  "gas-feecap",
+			Usage: "Gas fee cap of each message in attoFIL",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "gas-premium",
+			Usage: "Gas premium of each message in attoFIL",
+			Value: "0",
+		},
+		&cli.IntFlag{
+			Name:  "max-sectors",
+			Usage: "Sectors per message, 0 uses the network limit",
+		},
+		&cli.IntFlag{
+			Name:  "max-partitions",
+			Usage: "Partitions per message, 0 uses the network limit",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Message format (json, cbor)",
+			Value: MessagesFormatJSON,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output file, stdout if not specified",
+		},
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
+	},
+	Action: terminateMessages,
+}
+
+// TerminateMessagesOutput is the JSON form of the unsigned messages. Amounts are given in
+// attoFIL with full precision, the *_fil fields repeat them as FIL strings.
+type TerminateMessagesOutput struct {
+	MinerID        string                   `json:"miner_id"`
+	Epoch          abi.ChainEpoch           `json:"epoch"`
+	Messages       []TerminateMessageOutput `json:"messages"`
+	SkippedSectors []abi.SectorNumber       `json:"skipped_sectors"` // expired or not in any partition
+	TotalFee       string                   `json:"total_fee"`
+	TotalFeeFIL    string                   `json:"total_fee_fil"`
+}
+
+type TerminateMessageOutput struct {
+	Message         *types.Message      `json:"message"`
+	Mutable         bool                `json:"mutable"` // false: rejected until the deadline leaves its challenge window
+	Sectors         int                 `json:"sectors"`
+	Declarations    []DeclarationOutput `json:"declarations"`
+	EstimatedFee    string              `json:"estimated_fee"`
+	EstimatedFeeFIL string              `json:"estimated_fee_fil"`
+}
+
+type DeclarationOutput struct {
+	Deadline  uint64 `json:"deadline"`
+	Partition uint64 `json:"partition"`
+	Sectors   string `json:"sectors"` // ranges, e.g. "1-3,5"
+}
+
+func terminateMessages(c *cli.Context) error {
+	format := c.String("format")
+	if format != MessagesFormatJSON && format != MessagesFormatCBOR {
+		return fmt.Errorf("unsupported message format: %s", format)
+	}
+
+	sectorNumbers, err := getSectorSelection(c)
+	if err != nil {
+		return err
+	}
+	partitions, err := getPartitionFilter(c)
+	if err != nil {
+		return err
+	}
+	feeCap, err := big.FromString(c.String("gas-feecap"))
+	if err != nil {
+		return fmt.Errorf("invalid gas fee cap: %w", err)
+	}
+	premium, err := big.FromString(c.String("gas-premium"))
+	if err != nil {
+		return fmt.Errorf("invalid gas premium: %w", err)
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	snap, err := utils.LoadSnapshot(ctx, api, c.String("miner"), 0, sectorNumbers)
+	if err != nil {
+		return err
+	}
+	if partitions != nil {
+		snap = snap.Filter(*partitions)
+	}
+	result, err := snap.Evaluate(0, nil)
+	if err != nil {
+		return err
+	}
+
+	limits, err := utils.TerminationLimitsFor(snap.NetworkVersion())
+	if err != nil {
+		return err
+	}
+	if n := c.Int("max-sectors"); n > 0 {
+		limits.MaxSectors = min(n, limits.MaxSectors)
+	}
+	if n := c.Int("max-partitions"); n > 0 {
+		limits.MaxPartitions = min(n, limits.MaxPartitions)
+	}
+	batches, skipped, err := utils.PlanTerminations(result, limits)
+	if err != nil {
+		return err
+	}
+
+	to, err := address.NewFromString(result.MinerID)
+	if err != nil {
+		return err
+	}
+	from := snap.Worker()
+	if s := c.String("from"); s != "" {
+		if from, err = address.NewFromString(s); err != nil {
+			return fmt.Errorf("invalid sender address: %w", err)
+		}
+	}
+
+	out := TerminateMessagesOutput{
+		MinerID:        result.MinerID,
+		Epoch:          result.TargetEpoch,
+		Messages:       make([]TerminateMessageOutput, 0, len(batches)),
+		SkippedSectors: skipped,
+	}
+	if out.SkippedSectors == nil {
+		out.SkippedSectors = []abi.SectorNumber{}
+	}
+	totalFee := big.Zero()
+	for i, b := range batches {
+		var params bytes.Buffer
+		if err := b.Params().MarshalCBOR(&params); err != nil {
+			return fmt.Errorf("failed to serialize params: %w", err)
+		}
+		msg := &types.Message{
+			To:         to,
+			From:       from,
+			Nonce:      c.Uint64("nonce") + uint64(i),
+			Value:      big.Zero(),
+			GasLimit:   c.Int64("gas-limit"),
+			GasFeeCap:  feeCap,
+			GasPremium: premium,
+			Method:     stbuiltin.MethodsMiner.TerminateSectors,
+			Params:     params.Bytes(),
+		}
+
+		msgOut := TerminateMessageOutput{
+			Message:         msg,
+			Mutable:         b.Mutable,
+			Sectors:         b.Sectors,
+			EstimatedFee:    bigString(b.Fee),
+			EstimatedFeeFIL: types.FIL(b.Fee).String(),
+		}
+		for _, d := range b.Declarations {
+			sectors, err := formatSectorRanges(d.Sectors)
+			if err != nil {
+				return err
+			}
+			msgOut.Declarations = append(msgOut.Declarations, DeclarationOutput{Deadline: d.Deadline, Partition: d.Partition, Sectors: sectors})
+		}
+		out.Messages = append(out.Messages, msgOut)
+		totalFee = big.Add(totalFee, b.Fee)
+	}
+	out.TotalFee = bigString(totalFee)
+	out.TotalFeeFIL = types.FIL(totalFee).String()
+
+	w, info := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if filename := c.String("output"); filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch format {
+	case MessagesFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(out)
+	case MessagesFormatCBOR:
+		err = writeMessagesCBOR(w, batches, out.Messages)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write messages: %w", err)
+	}
+
+	// Zero values are valid in the message, but a node rejects them once signed
+	var unset []string
+	if !c.IsSet("nonce") {
+		unset = append(unset, "nonce")
+	}
+	if c.Int64("gas-limit") == 0 {
+		unset = append(unset, "gas limit")
+	}
+	if feeCap.Sign() == 0 {
+		unset = append(unset, "gas fee cap")
+	}
+	printTerminateSummary(info, out, unset)
+	return nil
+}
+
+// writeMessagesCBOR writes the messages as a CBOR array of [message, estimated fee] pairs
+func writeMessagesCBOR(w io.Writer, batches []utils.TerminationBatch, messages []TerminateMessageOutput) error {
+	cw := cbg.NewCborWriter(w)
+	if err := cw.WriteMajorTypeHeader(cbg.MajArray, uint64(len(messages))); err != nil {
+		return err
+	}
+	for i, m := range messages {
+		if err := cw.WriteMajorTypeHeader(cbg.MajArray, 2); err != nil {
+			return err
+		}
+		if err := m.Message.MarshalCBOR(cw); err != nil {
+			return err
+		}
+		if err := batches[i].Fee.MarshalCBOR(cw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTerminateSummary prints the totals and warnings of the messages, unset lists the
+// message fields left at zero for the signer to fill in
+func printTerminateSummary(w io.Writer, out TerminateMessagesOutput, unset []string) {
+	sectors, immutable := 0, 0
+	for _, m := range out.Messages {
+		sectors += m.Sectors
+		if !m.Mutable {
+			immutable++
+		}
+	}
+	fmt.Fprintf(w, "Built %d unsigned messages terminating %d sectors of %s, estimated fee %s\n",
+		len(out.Messages), sectors, out.MinerID, out.TotalFeeFIL)
+	if len(out.SkippedSectors) > 0 {
+		fmt.Fprintf(w, "Skipped %d expired or unassigned sectors\n", len(out.SkippedSectors))
+	}
+	if immutable > 0 {
+		fmt.Fprintf(w, "Warning: %d messages address deadlines that are currently immutable and will fail until their challenge window has passed\n", immutable)
+	}
+	if len(unset) > 0 && len(out.Messages) > 0 {
+		fmt.Fprintf(w, "Warning: the messages are not ready to sign, fill in the %s before signing (left at 0, see --nonce, --gas-limit and --gas-feecap)\n",
+			strings.Join(unset, ", "))
+	}
+}
+
+// formatSectorRanges formats a bitfield in the --sectors syntax, e.g. "1-3,5"
+func formatSectorRanges(bf bitfield.BitField) (string, error) {
+	it, err := bf.RunIterator()
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	var pos uint64
+	for it.HasNext() {
+		run, err := it.NextRun()
+		if err != nil {
+			return "", err
+		}
+		if run.Val {
+			switch run.Len {
+			case 1:
+				parts = append(parts, fmt.Sprintf("%d", pos))
+			default:
+				parts = append(parts, fmt.Sprintf("%d-%d", pos, pos+run.Len-1))
+			}
+		}
+		pos += run.Len
+	}
+	return strings.Join(parts, ","), nil
+}
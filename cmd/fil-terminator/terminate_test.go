@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/big"
+	stbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	stminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestTerminateMessagesCBORRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile, cborFile := filepath.Join(dir, "messages.json"), filepath.Join(dir, "messages.cbor")
+	args := []string{"terminate-messages", "--miner", testMiner, "--all", "--max-sectors", "1", "--nonce", "7"}
+	require.NoError(t, runApp(t, context.Background(), fixtureReader(t), append(args, "--output", jsonFile)...))
+	require.NoError(t, runApp(t, context.Background(), fixtureReader(t), append(args, "--format", "cbor", "--output", cborFile)...))
+
+	data, err := os.ReadFile(jsonFile)
+	require.NoError(t, err)
+	var planned TerminateMessagesOutput
+	require.NoError(t, json.Unmarshal(data, &planned))
+	require.Greater(t, len(planned.Messages), 1)
+
+	data, err = os.ReadFile(cborFile)
+	require.NoError(t, err)
+	cr := cbg.NewCborReader(bytes.NewReader(data))
+	maj, n, err := cr.ReadHeader()
+	require.NoError(t, err)
+	require.EqualValues(t, cbg.MajArray, maj)
+	require.EqualValues(t, len(planned.Messages), n)
+
+	for i, want := range planned.Messages {
+		// Every entry is a [message, estimated fee] pair
+		maj, n, err := cr.ReadHeader()
+		require.NoError(t, err)
+		require.EqualValues(t, cbg.MajArray, maj)
+		require.EqualValues(t, 2, n)
+		var msg types.Message
+		require.NoError(t, msg.UnmarshalCBOR(cr))
+		var fee big.Int
+		require.NoError(t, fee.UnmarshalCBOR(cr))
+
+		assert.Equal(t, want.Message.Cid(), msg.Cid())
+		assert.Equal(t, testMiner, msg.To.String())
+		assert.Equal(t, stbuiltin.MethodsMiner.TerminateSectors, msg.Method)
+		assert.EqualValues(t, 7+i, msg.Nonce)
+		assert.Equal(t, want.EstimatedFee, fee.String())
+
+		// The params hold the planned declarations
+		var params stminer.TerminateSectorsParams
+		require.NoError(t, params.UnmarshalCBOR(bytes.NewReader(msg.Params)))
+		require.Len(t, params.Terminations, len(want.Declarations))
+		for j, d := range params.Terminations {
+			sectors, err := formatSectorRanges(d.Sectors)
+			require.NoError(t, err)
+			assert.Equal(t, want.Declarations[j], DeclarationOutput{Deadline: d.Deadline, Partition: d.Partition, Sectors: sectors})
+		}
+	}
+	_, _, err = cr.ReadHeader()
+	assert.Error(t, err, "trailing data")
+}
+
+func TestTerminateSummaryUnsetFields(t *testing.T) {
+	out := TerminateMessagesOutput{MinerID: testMiner, Messages: []TerminateMessageOutput{{Mutable: true, Sectors: 1}}, TotalFeeFIL: "1"}
+
+	var buf bytes.Buffer
+	printTerminateSummary(&buf, out, []string{"nonce", "gas limit", "gas fee cap"})
+	assert.Contains(t, buf.String(), "not ready to sign, fill in the nonce, gas limit, gas fee cap")
+
+	buf.Reset()
+	printTerminateSummary(&buf, out, nil)
+	assert.False(t, strings.Contains(buf.String(), "not ready to sign"))
+}
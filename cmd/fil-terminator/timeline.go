@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+var timelineCmd = &cli.Command{
+	Name:        "timeline",
+	Usage:       "Calculate termination fees over a range of epochs",
+	Description: "Evaluate the termination fee of a sector set at every step between start and end epoch. Past epochs use real chain state, future epochs use the estimation path.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "miner",
+			Aliases:  []string{"m"},
+			Usage:    "Miner address",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "sectors",
+			Aliases: []string{"s"},
+			Usage:   "Sector number list, comma separated (e.g. 1,2,3 or 1-10)",
+		},
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Calculate all sectors",
+		},
+		&cli.Int64Flag{
+			Name:     "start",
+			Usage:    "Start epoch",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:     "end",
+			Usage:    "End epoch (inclusive)",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "step",
+			Usage: "Interval between evaluated epochs",
+			Value: 2880,
+		},
+		modelFlag,
+		modelParamsFlag,
+		modelFileFlag,
+		snapshotFlag,
+		retriesFlag,
+		retryBackoffFlag,
+		callTimeoutFlag,
+		&cli.StringFlag{
+			Name:    "format",
+			Aliases: []string{"f"},
+			Usage:   "Deprecated, use the global --output-format (table is text)",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output file path (optional, print to terminal if not specified)",
+		},
+	},
+	Action: timeline,
+}
+
+type TimelinePoint struct {
+	Epoch          abi.ChainEpoch `json:"epoch"`
+	IsEstimate     bool           `json:"is_estimate"`
+	TotalFee       string         `json:"total_fee"` // attoFIL
+	TotalFeeFIL    string         `json:"total_fee_fil"`
+	ActiveSectors  int            `json:"active_sectors"`
+	ExpiredSectors int            `json:"expired_sectors"`
+	Skipped        string         `json:"skipped,omitempty"` // why the point has no result, e.g. a sector not found
+}
+
+// timelineSnapshots loads the snapshots of a timeline. Future epochs share one snapshot
+// of the head, past epochs are cached by the height of the tipset they resolve to, so
+// epochs in null rounds share one load. Failed loads are cached as well.
+type timelineSnapshots struct {
+	api     utils.ChainReader
+	miner   string
+	sectors []abi.SectorNumber
+	head    abi.ChainEpoch
+
+	loaded map[abi.ChainEpoch]*utils.Snapshot
+	failed map[abi.ChainEpoch]error
+}
+
+func newTimelineSnapshots(api utils.ChainReader, miner string, sectors []abi.SectorNumber, head abi.ChainEpoch) *timelineSnapshots {
+	return &timelineSnapshots{
+		api:     api,
+		miner:   miner,
+		sectors: sectors,
+		head:    head,
+		loaded:  make(map[abi.ChainEpoch]*utils.Snapshot),
+		failed:  make(map[abi.ChainEpoch]error),
+	}
+}
+
+func (ts *timelineSnapshots) get(ctx context.Context, epoch abi.ChainEpoch) (*utils.Snapshot, error) {
+	height := ts.head
+	if epoch < ts.head {
+		tipset, err := ts.api.ChainGetTipSetByHeight(ctx, epoch, types.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tipset at epoch %d: %w", epoch, err)
+		}
+		height = tipset.Height()
+	}
+	if err, ok := ts.failed[height]; ok {
+		return nil, err
+	}
+
+	snap, ok := ts.loaded[height]
+	if !ok {
+		var err error
+		if snap, err = utils.LoadSnapshot(ctx, ts.api, ts.miner, min(epoch, ts.head), ts.sectors); err != nil {
+			ts.failed[height] = err
+			return nil, err
+		}
+		ts.loaded[height] = snap
+	}
+	if epoch < ts.head && epoch != snap.Epoch() {
+		return snap.WithEpoch(epoch), nil
+	}
+	return snap, nil
+}
+
+func countSkipped(points []TimelinePoint) int {
+	n := 0
+	for _, p := range points {
+		if p.Skipped != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func timeline(c *cli.Context) error {
+	start := abi.ChainEpoch(c.Int64("start"))
+	end := abi.ChainEpoch(c.Int64("end"))
+	step := abi.ChainEpoch(c.Int64("step"))
+	if start <= 0 || end < start {
+		return fmt.Errorf("invalid epoch range: %d - %d", start, end)
+	}
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+
+	format, err := timelineFormat(c)
+	if err != nil {
+		return err
+	}
+
+	sectorNumbers, err := getSectorSelection(c)
+	if err != nil {
+		return err
+	}
+
+	model, err := getNetworkModel(c)
+	if err != nil {
+		return fmt.Errorf("invalid projection model: %w", err)
+	}
+
+	api, closer, err := getChainReader(c)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current height: %w", err)
+	}
+	snapshots := newTimelineSnapshots(api, c.String("miner"), sectorNumbers, head.Height())
+
+	points := make([]TimelinePoint, 0, (end-start)/step+1)
+	for epoch := start; epoch <= end; epoch += step {
+		snap, err := snapshots.get(ctx, epoch)
+		if errors.Is(err, utils.ErrSectorNotFound) {
+			// Selected sectors may not be committed yet at early epochs
+			points = append(points, TimelinePoint{Epoch: epoch, IsEstimate: epoch > head.Height(), Skipped: err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := snap.Evaluate(epoch, model)
+		if err != nil {
+			return err
+		}
+
+		points = append(points, TimelinePoint{
+			Epoch:          result.TargetEpoch,
+			IsEstimate:     result.IsEstimate,
+			TotalFee:       result.TotalFee.String(),
+			TotalFeeFIL:    types.FIL(result.TotalFee).String(),
+			ActiveSectors:  result.ActiveSectors,
+			ExpiredSectors: result.ExpiredSectors,
+		})
+	}
+	if skipped := countSkipped(points); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d of %d points skipped, selected sectors not found\n", skipped, len(points))
+	}
+
+	var w io.Writer = os.Stdout
+	if c.String("output") != "" {
+		file, err := os.Create(c.String("output"))
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if format == FormatText {
+		printTimeline(w, points)
+		return nil
+	}
+	return writeRecords(w, format, points, timelineCSVHeader, timelineCSVRecord)
+}
+
+// timelineFormat returns the global --output-format, or the deprecated --format if set
+func timelineFormat(c *cli.Context) (string, error) {
+	if !c.IsSet("format") {
+		return getOutputFormat(c)
+	}
+	fmt.Fprintln(os.Stderr, "--format is deprecated, use the global --output-format")
+	switch format := c.String("format"); format {
+	case "table":
+		return FormatText, nil
+	case FormatCSV, FormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+var timelineCSVHeader = []string{"Epoch", "Estimate", "TotalFee(attoFIL)", "TotalFee(FIL)", "ActiveSectors", "ExpiredSectors", "Skipped"}
+
+func timelineCSVRecord(p TimelinePoint) []string {
+	return []string{
+		fmt.Sprintf("%d", p.Epoch),
+		fmt.Sprintf("%t", p.IsEstimate),
+		p.TotalFee,
+		p.TotalFeeFIL,
+		fmt.Sprintf("%d", p.ActiveSectors),
+		fmt.Sprintf("%d", p.ExpiredSectors),
+		p.Skipped,
+	}
+}
+
+func printTimeline(w io.Writer, points []TimelinePoint) {
+	fmt.Fprintf(w, "%-10s %-10s %-8s %-8s %s\n", "Epoch", "Mode", "Active", "Expired", "Fee(FIL)")
+	fmt.Fprintln(w, strings.Repeat("-", 60))
+
+	for _, p := range points {
+		mode := "historical"
+		if p.IsEstimate {
+			mode = "estimated"
+		}
+		if p.Skipped != "" {
+			fmt.Fprintf(w, "%-10d %-10s skipped: %s\n", p.Epoch, mode, p.Skipped)
+			continue
+		}
+		fmt.Fprintf(w, "%-10d %-10s %-8d %-8d %s\n", p.Epoch, mode, p.ActiveSectors, p.ExpiredSectors, p.TotalFeeFIL)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/strahe/fil-terminator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sparseChainReader serves every past height from the tipset at 900000, as on a chain
+// without blocks until the head, and only knows the selected sectors at the head
+type sparseChainReader struct {
+	countingReader
+	head types.TipSetKey
+}
+
+func newSparseChainReader(t *testing.T) *sparseChainReader {
+	api := fixtureReader(t, "historical", "sector-list")
+	head, err := api.ChainHead(context.Background())
+	require.NoError(t, err)
+	return &sparseChainReader{countingReader: countingReader{ChainReader: api}, head: head.Key()}
+}
+
+func (r *sparseChainReader) ChainGetTipSetByHeight(ctx context.Context, _ abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	return r.ChainReader.ChainGetTipSetByHeight(ctx, 900000, tsk)
+}
+
+func (r *sparseChainReader) StateSectorGetInfo(ctx context.Context, addr address.Address, n abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	if tsk != r.head {
+		return nil, nil
+	}
+	return r.ChainReader.StateSectorGetInfo(ctx, addr, n, tsk)
+}
+
+func readTimeline(t *testing.T, filename string) []TimelinePoint {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	var points []TimelinePoint
+	require.NoError(t, json.Unmarshal(data, &points))
+	return points
+}
+
+func TestTimelineCachesSnapshotPerTipset(t *testing.T) {
+	api := newSparseChainReader(t)
+	output := filepath.Join(t.TempDir(), "timeline.json")
+
+	require.NoError(t, runApp(t, context.Background(), api, "--output-format", "json",
+		"timeline", "--miner", testMiner, "--all", "--start", "900000", "--end", "1003000", "--step", "20600", "--output", output))
+
+	points := readTimeline(t, output)
+	require.Len(t, points, 6)
+	for i, p := range points[:5] {
+		assert.EqualValues(t, 900000+20600*i, p.Epoch)
+		assert.False(t, p.IsEstimate)
+		assert.Empty(t, p.Skipped)
+	}
+	assert.True(t, points[5].IsEstimate)
+	assert.Equal(t, points[0].ActiveSectors, points[4].ActiveSectors+points[4].ExpiredSectors)
+
+	// One load at 900000 for all past epochs, one at the head
+	assert.Equal(t, 2, api.loads)
+}
+
+func TestTimelineSkipsMissingSectors(t *testing.T) {
+	api := newSparseChainReader(t)
+	output := filepath.Join(t.TempDir(), "timeline.json")
+
+	require.NoError(t, runApp(t, context.Background(), api, "--output-format", "json",
+		"timeline", "--miner", testMiner, "--sectors", "2,3", "--start", "994240", "--end", "1002880", "--output", output))
+
+	points := readTimeline(t, output)
+	require.Len(t, points, 4)
+	for _, p := range points[:2] {
+		assert.Contains(t, p.Skipped, utils.ErrSectorNotFound.Error())
+		assert.Empty(t, p.TotalFee)
+	}
+	for _, p := range points[2:] {
+		assert.Empty(t, p.Skipped)
+		assert.Equal(t, 2, p.ActiveSectors+p.ExpiredSectors)
+	}
+}
@@ -58,6 +58,42 @@ var toolsCmd = &cli.Command{
 				},
 			},
 		},
+		{
+			Name:        "record-fixture",
+			Usage:       "Record the chain reads of a calculation into a test fixture",
+			Description: "Run a calculation against the Lotus node (or --snapshot) and save every RPC response and IPLD block it read, so the calculation can be replayed offline in tests.",
+			Action:      recordFixtureAction,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "miner",
+					Aliases:  []string{"m"},
+					Usage:    "Miner address",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "sectors",
+					Aliases: []string{"s"},
+					Usage:   "Sector number list, comma separated (e.g. 1,2,3 or 1-10)",
+				},
+				&cli.BoolFlag{
+					Name:    "all",
+					Aliases: []string{"a"},
+					Usage:   "Calculate all sectors",
+				},
+				&cli.Int64Flag{
+					Name:    "epoch",
+					Aliases: []string{"e"},
+					Usage:   "Target epoch, use current height if not specified",
+				},
+				snapshotFlag,
+				&cli.StringFlag{
+					Name:     "output",
+					Aliases:  []string{"o"},
+					Usage:    "Output fixture file path",
+					Required: true,
+				},
+			},
+		},
 	},
 }
 
@@ -154,3 +190,38 @@ func getGenesisTime(cctx *cli.Context, offline bool) (time.Time, error) {
 	genesisTime := time.Unix(int64(genesis.Blocks()[0].Timestamp), 0)
 	return genesisTime, nil
 }
+
+func recordFixtureAction(cctx *cli.Context) error {
+	sectorNumbers, err := getSectorSelection(cctx)
+	if err != nil {
+		return err
+	}
+
+	api, closer, err := getChainReader(cctx)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	ctx, cancel := context.WithCancel(cctx.Context)
+	defer cancel()
+
+	rec := utils.NewRecordingReader(api)
+	_, err = utils.CalculateTerminationFee(ctx, rec, utils.CalculationRequest{
+		MinerID:       cctx.String("miner"),
+		TargetEpoch:   abi.ChainEpoch(cctx.Int64("epoch")),
+		SectorNumbers: sectorNumbers,
+	})
+	if err != nil {
+		// Failed calculations are recorded too, they make error path fixtures
+		fmt.Printf("Calculation failed: %v\n", err)
+	}
+
+	fixture := rec.Fixture()
+	if err := utils.SaveFixture(cctx.String("output"), fixture); err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+	fmt.Printf("Recorded %d calls and %d blocks to %s\n", len(fixture.Calls), len(fixture.Blocks), cctx.String("output"))
+
+	return nil
+}
@@ -4,35 +4,65 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
-	stactors "github.com/filecoin-project/go-state-types/actors"
 	"github.com/filecoin-project/go-state-types/big"
+	stbuiltin "github.com/filecoin-project/go-state-types/builtin"
 	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
-	"github.com/filecoin-project/lotus/api"
-	"github.com/filecoin-project/lotus/blockstore"
-	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/adt"
 	"github.com/filecoin-project/lotus/chain/actors/builtin"
-	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
 	"github.com/filecoin-project/lotus/chain/types"
-	cbor "github.com/ipfs/go-ipld-cbor"
 )
 
 type CalculationRequest struct {
 	MinerID       string
 	TargetEpoch   abi.ChainEpoch
 	SectorNumbers []abi.SectorNumber // empty means all sectors
+	Model         NetworkModel       // projection model for estimates, nil means DefaultNetworkModel
+	Partitions    *PartitionFilter   // nil means sectors of all deadlines
 }
 
+// Terms of PledgePenaltyForTermination, the binding term is the one that sets the fee
+const (
+	TermAge       = "age"        // age-scaled share of the pledge multiple or day reward, sector younger than the lifetime cap
+	TermPledgeCap = "pledge-cap" // full pledge multiple, sector age reached the lifetime cap
+	TermMinPledge = "min-pledge" // absolute minimum share of initial pledge
+	TermFaultFee  = "fault-fee"  // multiple of the continued fault fee
+
+	// Terms of the reward based formulas before actors v16
+	TermRewardCap  = "reward-cap"  // full lifetime cap of day reward
+	TermLowerBound = "lower-bound" // SP(t), projected reward of the sector power
+)
+
 type SectorResult struct {
-	SectorNumber abi.SectorNumber
-	Fee          big.Int
-	Age          abi.ChainEpoch
-	IsExpired    bool
-	ExpiredDays  float64
+	SectorNumber  abi.SectorNumber
+	Status        SectorStatus // partition state at the snapshot epoch
+	Deadline      uint64       // deadline and partition the sector is assigned to, see Status
+	Partition     uint64
+	Fee           big.Int
+	Age           abi.ChainEpoch
+	Activation    abi.ChainEpoch
+	Expiration    abi.ChainEpoch
+	IsExpired     bool
+	ExpiredDays   float64
+	FaultFee      big.Int          // PledgePenaltyForContinuedFault
+	QAPower       abi.StoragePower // quality adjusted power of the sector
+	InitialPledge abi.TokenAmount
+	BindingTerm   string // term of the termination penalty that set the fee
+	Approximate   bool   // fee may be understated, the replaced sector of a capacity upgrade is unknown
+}
+
+// ProjectionInfo describes how network parameters were projected for an estimate
+type ProjectionInfo struct {
+	Model  string
+	Epochs abi.ChainEpoch
+	Params map[string]float64
+}
+
+// String returns the projection model name followed by its parameters
+func (p ProjectionInfo) String() string {
+	return fmt.Sprintf("%s(%s)", p.Model, FormatModelParams(p.Params))
 }
 
 type CalculationResult struct {
@@ -40,195 +70,79 @@ type CalculationResult struct {
 	TargetEpoch    abi.ChainEpoch
 	CurrentEpoch   abi.ChainEpoch
 	IsEstimate     bool
+	Formula        string          // termination fee formula generation, see FormulaPledge
+	Projection     *ProjectionInfo // nil unless IsEstimate
 	TotalSectors   int
 	ActiveSectors  int
 	ExpiredSectors int
 	TotalFee       big.Int
+	TotalPledge    big.Int // initial pledge of active sectors
 	SectorResults  []SectorResult
-	Error          string
-}
-
-func CalculateTerminationFee(ctx context.Context, api api.FullNode, req CalculationRequest) CalculationResult {
-	result := CalculationResult{
-		MinerID:     req.MinerID,
-		TargetEpoch: req.TargetEpoch,
-	}
 
-	// Parse miner address
-	mid, err := address.NewFromString(req.MinerID)
-	if err != nil {
-		result.Error = fmt.Sprintf("invalid miner address: %v", err)
-		return result
-	}
-
-	bstore := blockstore.NewAPIBlockstore(api)
-	adtStore := adt.WrapStore(ctx, cbor.NewCborStore(bstore))
-
-	// Get current tipset
-	currentTs, err := api.ChainHead(ctx)
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to get current height: %v", err)
-		return result
-	}
-
-	result.CurrentEpoch = currentTs.Height()
-
-	// Determine target epoch
-	if req.TargetEpoch == 0 {
-		req.TargetEpoch = currentTs.Height()
-	}
-	result.TargetEpoch = req.TargetEpoch
-
-	// Determine if estimation is needed
-	result.IsEstimate = req.TargetEpoch > currentTs.Height()
-
-	var ts *types.TipSet
-	if result.IsEstimate {
-		// Future estimation, use current data
-		ts = currentTs
-	} else {
-		// Historical data, get actual tipset
-		ts, err = api.ChainGetTipSetByHeight(ctx, req.TargetEpoch, types.EmptyTSK)
-		if err != nil {
-			result.Error = fmt.Sprintf("failed to get tipset at epoch %d: %v", req.TargetEpoch, err)
-			return result
-		}
-	}
-
-	nv, err := api.StateNetworkVersion(ctx, ts.Key())
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to get network version: %v", err)
-		return result
-	}
-
-	minerAct, err := api.StateGetActor(ctx, mid, ts.Key())
-	if err != nil {
-		result.Error = fmt.Sprintf("failed to get miner actor: %v", err)
-		return result
-	}
+	TerminatedSectors  int              // listed sectors already terminated, not in SectorResults
+	ApproximateSectors int              // active sectors with an approximate fee, see SectorResult.Approximate
+	StatusTotals       []StatusTotal    // non-expired sectors by status, in SectorStatuses order
+	PartitionTotals    []PartitionTotal // non-expired sectors by deadline and partition
+}
 
-	minerInfo, err := api.StateMinerInfo(ctx, mid, ts.Key())
+// CalculateTerminationFee loads a snapshot for the request and evaluates it at the target epoch.
+// Errors are *CalculationError.
+func CalculateTerminationFee(ctx context.Context, api ChainReader, req CalculationRequest) (CalculationResult, error) {
+	snap, err := LoadSnapshot(ctx, api, req.MinerID, req.TargetEpoch, req.SectorNumbers)
 	if err != nil {
-		result.Error = fmt.Sprintf("failed to get miner info: %v", err)
-		return result
+		return CalculationResult{}, err
 	}
-
-	var minerVersion stactors.Version
-	if _, version, ok := actors.GetActorMetaByCode(minerAct.Code); !ok {
-		result.Error = fmt.Sprintf("unsupported miner actor code: %s", minerAct.Code)
-		return result
-	} else {
-		minerVersion = version
-	}
-
-	if minerVersion < stactors.Version16 {
-		result.Error = fmt.Sprintf("unsupported miner version %d", minerVersion)
-		return result
+	if req.Partitions != nil {
+		snap = snap.Filter(*req.Partitions)
 	}
 
-	// Get sectors
-	var sectors []*miner.SectorOnChainInfo
-	if len(req.SectorNumbers) == 0 {
-		// Get all sectors
-		sectors, err = api.StateMinerSectors(ctx, mid, nil, ts.Key())
-		if err != nil {
-			result.Error = fmt.Sprintf("failed to get sectors: %v", err)
-			return result
-		}
-	} else {
-		// Get specific sectors
-		for _, num := range req.SectorNumbers {
-			info, err := api.StateSectorGetInfo(ctx, mid, num, ts.Key())
-			if err != nil {
-				result.Error = fmt.Sprintf("failed to get sector %d info: %v", num, err)
-				return result
-			}
-			sectors = append(sectors, info)
-		}
-	}
-
-	result.TotalSectors = len(sectors)
+	return snap.Evaluate(req.TargetEpoch, req.Model)
+}
 
-	// Get network parameters
+// loadNetworkEstimates loads the smoothed reward and power estimates at the given tipset
+func loadNetworkEstimates(ctx context.Context, api ChainReader, adtStore adt.Store, tsk types.TipSetKey) (builtin.FilterEstimate, builtin.FilterEstimate, error) {
 	var rewardSmoothed, powerSmoothed builtin.FilterEstimate
 
-	if act, err := api.StateGetActor(ctx, reward.Address, ts.Key()); err != nil {
-		result.Error = fmt.Sprintf("failed to load reward actor: %v", err)
-		return result
+	if act, err := api.StateGetActor(ctx, reward.Address, tsk); err != nil {
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to load reward actor: %w", err)
 	} else if s, err := reward.Load(adtStore, act); err != nil {
-		result.Error = fmt.Sprintf("failed to load reward actor state: %v", err)
-		return result
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to load reward actor state: %w", err)
 	} else if rewardSmoothed, err = s.ThisEpochRewardSmoothed(); err != nil {
-		result.Error = fmt.Sprintf("failed to get smoothed reward: %v", err)
-		return result
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to get smoothed reward: %w", err)
 	}
 
-	if act, err := api.StateGetActor(ctx, power.Address, ts.Key()); err != nil {
-		result.Error = fmt.Sprintf("failed to load power actor: %v", err)
-		return result
+	if act, err := api.StateGetActor(ctx, power.Address, tsk); err != nil {
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to load power actor: %w", err)
 	} else if s, err := power.Load(adtStore, act); err != nil {
-		result.Error = fmt.Sprintf("failed to load power actor state: %v", err)
-		return result
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to load power actor state: %w", err)
 	} else if powerSmoothed, err = s.TotalPowerSmoothed(); err != nil {
-		result.Error = fmt.Sprintf("failed to get total power: %v", err)
-		return result
+		return rewardSmoothed, powerSmoothed, fmt.Errorf("failed to get total power: %w", err)
 	}
 
-	// Calculate fees
-	totalFee := big.Zero()
-	expiredSectors := 0
-	sectorResults := make([]SectorResult, 0, len(sectors))
-
-	for _, sector := range sectors {
-		sectorResult := SectorResult{
-			SectorNumber: sector.SectorNumber,
-		}
-
-		// Check if sector has expired at target epoch
-		if req.TargetEpoch >= sector.Expiration {
-			expiredSectors++
-			sectorResult.IsExpired = true
-			sectorResult.ExpiredDays = EpochsToDays(req.TargetEpoch - sector.Expiration)
-			sectorResults = append(sectorResults, sectorResult)
-			continue
-		}
-
-		// Calculate sector age
-		sectorAge := req.TargetEpoch - sector.Activation
-		sectorResult.Age = sectorAge
-
-		faultFee, err := miner.PledgePenaltyForContinuedFault(
-			nv,
-			builtin.FilterEstimate{
-				PositionEstimate: rewardSmoothed.PositionEstimate,
-				VelocityEstimate: rewardSmoothed.VelocityEstimate,
-			},
-			builtin.FilterEstimate{
-				PositionEstimate: powerSmoothed.PositionEstimate,
-				VelocityEstimate: powerSmoothed.VelocityEstimate,
-			},
-			stactorsminer.QAPowerForSector(minerInfo.SectorSize, sector),
-		)
-		if err != nil {
-			result.Error = fmt.Sprintf("failed to calculate fault fee: %v", err)
-			return result
-		}
-
-		fee, err := miner.PledgePenaltyForTermination(nv, sector.InitialPledge, sectorAge, faultFee)
-		if err != nil {
-			result.Error = fmt.Sprintf("failed to calculate termination fee: %v", err)
-			return result
-		}
-
-		sectorResult.Fee = fee
-		totalFee = big.Add(totalFee, fee)
-		sectorResults = append(sectorResults, sectorResult)
+	return rewardSmoothed, powerSmoothed, nil
+}
+
+// terminationFeeTerm reports which term of PledgePenaltyForTermination sets the fee,
+// following the same comparisons as the actor
+func terminationFeeTerm(initialPledge abi.TokenAmount, sectorAge abi.ChainEpoch, faultFee abi.TokenAmount) string {
+	simpleFee := big.Div(big.Mul(initialPledge, stactorsminer.TermFeePledgeMultiple.Numerator), stactorsminer.TermFeePledgeMultiple.Denominator)
+	durationFee := big.Div(big.Mul(big.NewInt(int64(sectorAge)), simpleFee), big.NewInt(int64(stactorsminer.TerminationLifetimeCap*stbuiltin.EpochsInDay)))
+
+	baseFee, baseTerm := durationFee, TermAge
+	if simpleFee.LessThanEqual(durationFee) {
+		baseFee, baseTerm = simpleFee, TermPledgeCap
 	}
 
-	result.ExpiredSectors = expiredSectors
-	result.ActiveSectors = result.TotalSectors - expiredSectors
-	result.TotalFee = totalFee
-	result.SectorResults = sectorResults
+	minFeeAbs := big.Div(big.Mul(initialPledge, stactorsminer.TermFeeMinPledgeMultiple.Numerator), stactorsminer.TermFeeMinPledgeMultiple.Denominator)
+	minFeeFf := big.Div(big.Mul(faultFee, stactorsminer.TermFeeMaxFaultFeeMultiple.Numerator), stactorsminer.TermFeeMaxFaultFeeMultiple.Denominator)
+
+	minFee, minTerm := minFeeAbs, TermMinPledge
+	if minFeeFf.GreaterThan(minFeeAbs) {
+		minFee, minTerm = minFeeFf, TermFaultFee
+	}
 
-	return result
+	if baseFee.GreaterThanEqual(minFee) {
+		return baseTerm
+	}
+	return minTerm
 }
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerminationFeeTerm(t *testing.T) {
+	pledge := big.NewInt(1_000_000_000)
+
+	tests := []struct {
+		name     string
+		age      abi.ChainEpoch
+		faultFee big.Int
+		expected string
+	}{
+		{
+			name:     "young sector uses absolute minimum",
+			age:      2880,
+			faultFee: big.NewInt(1000),
+			expected: TermMinPledge,
+		},
+		{
+			name:     "young sector with large fault fee",
+			age:      2880,
+			faultFee: big.NewInt(50_000_000),
+			expected: TermFaultFee,
+		},
+		{
+			name:     "middle aged sector scales with age",
+			age:      100 * 2880,
+			faultFee: big.NewInt(1000),
+			expected: TermAge,
+		},
+		{
+			name:     "old sector reaches lifetime cap",
+			age:      200 * 2880,
+			faultFee: big.NewInt(1000),
+			expected: TermPledgeCap,
+		},
+		{
+			name:     "fault fee above pledge cap",
+			age:      200 * 2880,
+			faultFee: big.NewInt(100_000_000),
+			expected: TermFaultFee,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, terminationFeeTerm(pledge, tt.age, tt.faultFee))
+		})
+	}
+}
+
+func TestTerminationFeeTermMatchesPenalty(t *testing.T) {
+	pledge := big.NewInt(1_000_000_000)
+	faultFee := big.NewInt(1000)
+
+	// The binding term must produce exactly the penalty computed by the actor
+	termFee := func(term string, age abi.ChainEpoch) big.Int {
+		simple := big.Div(big.Mul(pledge, big.NewInt(85)), big.NewInt(1000))
+		switch term {
+		case TermAge:
+			return big.Div(big.Mul(big.NewInt(int64(age)), simple), big.NewInt(140*2880))
+		case TermPledgeCap:
+			return simple
+		case TermMinPledge:
+			return big.Div(big.Mul(pledge, big.NewInt(2)), big.NewInt(100))
+		default:
+			return big.Div(big.Mul(faultFee, big.NewInt(105)), big.NewInt(100))
+		}
+	}
+
+	for _, days := range []abi.ChainEpoch{0, 1, 30, 33, 34, 100, 139, 140, 141, 540} {
+		age := days * 2880
+		term := terminationFeeTerm(pledge, age, faultFee)
+		expected := stactorsminer.PledgePenaltyForTermination(pledge, age, faultFee)
+		assert.Equal(t, expected, termFee(term, age), "age %d days, term %s", days, term)
+	}
+}
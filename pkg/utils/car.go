@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	stactors "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// CarReader serves chain and state reads from a local CAR export, such as the output of
+// `lotus chain export`. The CAR roots must be the tipset key of the exported head, and
+// only state roots included in the export can be queried.
+type CarReader struct {
+	bs   *carblockstore.ReadOnly
+	cst  cbor.IpldStore
+	head *types.TipSet
+}
+
+var _ ChainReader = (*CarReader)(nil)
+
+// OpenCarReader opens a CAR file and resolves its head tipset from the CAR roots
+func OpenCarReader(ctx context.Context, path string) (*CarReader, error) {
+	bs, err := carblockstore.OpenReadOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CAR file %s: %w", path, err)
+	}
+
+	roots, err := bs.Roots()
+	if err != nil {
+		_ = bs.Close()
+		return nil, fmt.Errorf("failed to read CAR roots: %w", err)
+	}
+
+	r := &CarReader{
+		bs:  bs,
+		cst: cbor.NewCborStore(bs),
+	}
+
+	r.head, err = r.loadTipSet(ctx, types.NewTipSetKey(roots...))
+	if err != nil {
+		_ = bs.Close()
+		return nil, fmt.Errorf("failed to load head tipset from CAR roots: %w", err)
+	}
+
+	return r, nil
+}
+
+// Close releases the underlying CAR file
+func (r *CarReader) Close() error {
+	return r.bs.Close()
+}
+
+func (r *CarReader) ChainHead(context.Context) (*types.TipSet, error) {
+	return r.head, nil
+}
+
+// ChainGetTipSetByHeight walks back from the head (or from tsk if set) and returns the
+// tipset at the given height, or the closest one before it when the height is a null round
+func (r *CarReader) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts, err := r.tipSetOrHead(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	if height > ts.Height() {
+		return nil, fmt.Errorf("looking for tipset with height greater than start point")
+	}
+
+	for ts.Height() > height {
+		ts, err = r.loadTipSet(ctx, ts.Parents())
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk back to height %d: %w", height, err)
+		}
+	}
+	return ts, nil
+}
+
+func (r *CarReader) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	blk, err := r.bs.Get(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("block %s not found in CAR: %w", c, err)
+	}
+	return blk.RawData(), nil
+}
+
+func (r *CarReader) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	return r.bs.Has(ctx, c)
+}
+
+// StateNetworkVersion derives the network version from the actors version of the state,
+// read from the code of the system actor. Exports of networks whose actors this build does
+// not know fail with ErrUnsupportedNetwork.
+func (r *CarReader) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	ts, err := r.tipSetOrHead(ctx, tsk)
+	if err != nil {
+		return 0, err
+	}
+
+	system, err := r.StateGetActor(ctx, builtin.SystemActorAddr, ts.Key())
+	if err != nil {
+		return 0, fmt.Errorf("failed to load system actor: %w", err)
+	}
+	name, av, ok := actors.GetActorMetaByCode(system.Code)
+	if !ok || name != manifest.SystemKey {
+		return 0, fmt.Errorf("%w: system actor code %s, the export is from an unknown network", ErrUnsupportedNetwork, system.Code)
+	}
+	return networkVersionFor(av, ts.Height())
+}
+
+func (r *CarReader) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	ts, err := r.tipSetOrHead(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := state.LoadStateTree(r.cst, ts.ParentState())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state tree: %w", err)
+	}
+	return tree.GetActor(addr)
+}
+
+func (r *CarReader) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	mas, err := r.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return api.MinerInfo{}, err
+	}
+
+	info, err := mas.Info()
+	if err != nil {
+		return api.MinerInfo{}, err
+	}
+
+	var pid *peer.ID
+	if peerID, err := peer.IDFromBytes(info.PeerId); err == nil {
+		pid = &peerID
+	}
+
+	ret := api.MinerInfo{
+		Owner:                      info.Owner,
+		Worker:                     info.Worker,
+		ControlAddresses:           info.ControlAddresses,
+		NewWorker:                  address.Undef,
+		WorkerChangeEpoch:          -1,
+		PeerId:                     pid,
+		Multiaddrs:                 info.Multiaddrs,
+		WindowPoStProofType:        info.WindowPoStProofType,
+		SectorSize:                 info.SectorSize,
+		WindowPoStPartitionSectors: info.WindowPoStPartitionSectors,
+		ConsensusFaultElapsed:      info.ConsensusFaultElapsed,
+		PendingOwnerAddress:        info.PendingOwnerAddress,
+		Beneficiary:                info.Beneficiary,
+		BeneficiaryTerm:            &info.BeneficiaryTerm,
+		PendingBeneficiaryTerm:     info.PendingBeneficiaryTerm,
+	}
+	if info.PendingWorkerKey != nil {
+		ret.NewWorker = info.PendingWorkerKey.NewWorker
+		ret.WorkerChangeEpoch = info.PendingWorkerKey.EffectiveAt
+	}
+
+	return ret, nil
+}
+
+func (r *CarReader) StateMinerSectors(ctx context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	mas, err := r.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return mas.LoadSectors(filter)
+}
+
+func (r *CarReader) StateSectorGetInfo(ctx context.Context, addr address.Address, num abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	mas, err := r.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return mas.GetSector(num)
+}
+
+func (r *CarReader) loadMiner(ctx context.Context, addr address.Address, tsk types.TipSetKey) (miner.State, error) {
+	act, err := r.StateGetActor(ctx, addr, tsk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load miner actor: %w", err)
+	}
+	return miner.Load(adt.WrapStore(ctx, r.cst), act)
+}
+
+func (r *CarReader) tipSetOrHead(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	if tsk.IsEmpty() {
+		return r.head, nil
+	}
+	return r.loadTipSet(ctx, tsk)
+}
+
+func (r *CarReader) loadTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	if r.head != nil && tsk == r.head.Key() {
+		return r.head, nil
+	}
+
+	cids := tsk.Cids()
+	blks := make([]*types.BlockHeader, 0, len(cids))
+	for _, c := range cids {
+		data, err := r.ChainReadObj(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		blk, err := types.DecodeBlock(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block header %s: %w", c, err)
+		}
+		blks = append(blks, blk)
+	}
+	return types.NewTipSet(blks)
+}
+
+// networkVersionAt returns the network version at the given height on mainnet. A network
+// version takes effect the epoch after its upgrade height.
+func networkVersionAt(height abi.ChainEpoch) network.Version {
+	nv := buildconstants.GenesisNetworkVersion
+	for _, upgrade := range filcns.DefaultUpgradeSchedule() {
+		if upgrade.Height >= 0 && height > upgrade.Height && upgrade.Network > nv {
+			nv = upgrade.Network
+		}
+	}
+	return nv
+}
+
+// networkVersionFor returns the network version of a state with actors version av at the
+// given height. The mainnet version at that height is used if it runs av, as several
+// network versions share one actors version, otherwise the last network version running av.
+func networkVersionFor(av stactors.Version, height abi.ChainEpoch) (network.Version, error) {
+	if v, err := stactors.VersionForNetwork(networkVersionAt(height)); err == nil && v == av {
+		return networkVersionAt(height), nil
+	}
+
+	var last network.Version
+	found := false
+	for nv := network.Version0; ; nv++ {
+		v, err := stactors.VersionForNetwork(nv)
+		if err != nil {
+			break
+		}
+		if v == av {
+			last, found = nv, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("%w: actors version %d", ErrUnsupportedNetwork, av)
+	}
+	return last, nil
+}
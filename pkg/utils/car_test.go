@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	stactors "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkVersionAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		height   abi.ChainEpoch
+		expected network.Version
+	}{
+		{
+			name:     "genesis",
+			height:   0,
+			expected: buildconstants.GenesisNetworkVersion,
+		},
+		{
+			name:     "at upgrade height",
+			height:   buildconstants.UpgradeTeepHeight,
+			expected: network.Version24,
+		},
+		{
+			name:     "after upgrade height",
+			height:   buildconstants.UpgradeTeepHeight + 1,
+			expected: network.Version25,
+		},
+		{
+			name:     "latest",
+			height:   buildconstants.UpgradeTockHeight + 1,
+			expected: network.Version26,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, networkVersionAt(tt.height))
+		})
+	}
+}
+
+// writeTestCar exports a head tipset at height whose state holds only a system actor
+// with the given code
+func writeTestCar(t *testing.T, height abi.ChainEpoch, systemCode cid.Cid) string {
+	ctx := context.Background()
+	bs := blockstore.NewMemory()
+	tree, err := state.NewStateTree(cbor.NewCborStore(bs), types.StateTreeVersion5)
+	require.NoError(t, err)
+	require.NoError(t, tree.SetActor(builtin.SystemActorAddr, &types.Actor{Code: systemCode, Head: systemCode, Balance: big.Zero()}))
+	root, err := tree.Flush(ctx)
+	require.NoError(t, err)
+
+	ts := fakeTipSetAt(t, height, nil, root)
+	blk, err := ts.Blocks()[0].ToStorageBlock()
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, blk))
+
+	path := filepath.Join(t.TempDir(), "export.car")
+	car, err := carblockstore.OpenReadWrite(path, ts.Cids())
+	require.NoError(t, err)
+	keys, err := bs.AllKeysChan(ctx)
+	require.NoError(t, err)
+	for c := range keys {
+		b, err := bs.Get(ctx, c)
+		require.NoError(t, err)
+		require.NoError(t, car.Put(ctx, b))
+	}
+	require.NoError(t, car.Finalize())
+	return path
+}
+
+func TestCarReaderNetworkVersion(t *testing.T) {
+	v16, ok := actors.GetActorCodeID(stactors.Version16, manifest.SystemKey)
+	require.True(t, ok)
+	v13, ok := actors.GetActorCodeID(stactors.Version13, manifest.SystemKey)
+	require.True(t, ok)
+
+	tests := []struct {
+		name     string
+		height   abi.ChainEpoch
+		code     cid.Cid
+		expected network.Version
+		err      error
+	}{
+		{name: "mainnet", height: buildconstants.UpgradeTeepHeight + 1, code: v16, expected: network.Version25},
+		{name: "mainnet after tock", height: buildconstants.UpgradeTockHeight + 1, code: v16, expected: network.Version26},
+		{name: "other schedule", height: 1000, code: v16, expected: network.Version26},
+		{name: "older actors", height: buildconstants.UpgradeTockHeight + 1, code: v13, expected: network.Version22},
+		{name: "unknown network", height: 1000, code: cid.MustParse("bafkqaaa"), err: ErrUnsupportedNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r, err := OpenCarReader(ctx, writeTestCar(t, tt.height, tt.code))
+			require.NoError(t, err)
+			defer r.Close()
+
+			nv, err := r.StateNetworkVersion(ctx, types.EmptyTSK)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, nv)
+		})
+	}
+}
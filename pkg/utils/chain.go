@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// ChainReader is the subset of chain and state reads the calculator depends on
+type ChainReader interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	ChainGetTipSetByHeight(context.Context, abi.ChainEpoch, types.TipSetKey) (*types.TipSet, error)
+	ChainReadObj(context.Context, cid.Cid) ([]byte, error)
+	ChainHasObj(context.Context, cid.Cid) (bool, error)
+
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
+	StateGetActor(context.Context, address.Address, types.TipSetKey) (*types.Actor, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateMinerSectors(context.Context, address.Address, *bitfield.BitField, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
+	StateSectorGetInfo(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (*miner.SectorOnChainInfo, error)
+}
+
+var _ ChainReader = (api.FullNode)(nil)
+
+// FullNodeReader adapts a Lotus full node API to a ChainReader
+func FullNodeReader(node api.FullNode) ChainReader {
+	return node
+}
+
+// NewChainStore returns an actor state store that reads blocks through the chain reader
+func NewChainStore(ctx context.Context, r ChainReader) adt.Store {
+	return adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(readOnlyChainIO{r})))
+}
+
+// readOnlyChainIO exposes the block reads of a ChainReader as a blockstore.ChainIO
+type readOnlyChainIO struct {
+	ChainReader
+}
+
+func (readOnlyChainIO) ChainPutObj(context.Context, blocks.Block) error {
+	return fmt.Errorf("chain reader is read only")
+}
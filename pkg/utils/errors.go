@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Causes of a failed calculation, match them with errors.Is
+var (
+	ErrInvalidAddress     = errors.New("invalid miner address")
+	ErrActorNotFound      = errors.New("actor not found")
+	ErrUnsupportedActor   = errors.New("unsupported miner actor")
+	ErrUnsupportedNetwork = errors.New("unsupported network version")
+	ErrSectorNotFound     = errors.New("sector not found")
+	ErrInvalidEpoch       = errors.New("invalid target epoch")
+	ErrChainRead          = errors.New("chain read failed") // RPC or store failure, see IsRetryable
+)
+
+// Stage is the step of a calculation that failed
+type Stage string
+
+const (
+	StageParseAddress     Stage = "parse address"
+	StageChainHead        Stage = "get chain head"
+	StageTipSet           Stage = "get tipset"
+	StageNetworkVersion   Stage = "get network version"
+	StageMinerActor       Stage = "get miner actor"
+	StageMinerInfo        Stage = "get miner info"
+	StageSectors          Stage = "get sectors"
+	StageSectorStatus     Stage = "get sector status"
+	StageNetworkEstimates Stage = "get network estimates"
+	StageFaultFee         Stage = "calculate fault fee"
+	StageTerminationFee   Stage = "calculate termination fee"
+)
+
+// CalculationError is returned by LoadSnapshot, Snapshot.Evaluate and CalculateTerminationFee.
+// Err wraps one of the Err* causes above.
+type CalculationError struct {
+	MinerID string
+	Epoch   abi.ChainEpoch    // requested epoch, 0 means the chain head
+	Stage   Stage             // step that failed
+	Sector  *abi.SectorNumber // sector being processed, nil for miner level failures
+	Err     error
+}
+
+func (e *CalculationError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.MinerID)
+	if e.Epoch != 0 {
+		fmt.Fprintf(&b, " at epoch %d", e.Epoch)
+	}
+	fmt.Fprintf(&b, ": %s", e.Stage)
+	if e.Sector != nil {
+		fmt.Fprintf(&b, " (sector %d)", *e.Sector)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+func (e *CalculationError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a transient chain read failure that may succeed when
+// retried: a timed out call, a lost connection or an overloaded node. Failures the node
+// would report again, like a decode error or a pruned state, are not retryable, nor are
+// invalid requests, missing actors or sectors and unsupported versions.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrChainRead) && !errors.Is(err, context.Canceled) && isTransient(err)
+}
+
+// transientMessages mark transport failures whose type did not survive JSON-RPC
+var transientMessages = []string{
+	"i/o timeout",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"websocket connection closed",
+	"http status 429",
+	"http status 5",
+}
+
+// isTransient reports whether err is a timeout, a connection failure or an HTTP 429/5xx
+// response of the JSON-RPC transport
+func isTransient(err error) bool {
+	var connErr *jsonrpc.RPCConnectionError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.As(err, &connErr),
+		errors.As(err, &netErr):
+		return true
+	}
+
+	msg := err.Error()
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainReadError classifies an error returned by a ChainReader. Lotus reports a missing
+// actor only through its message, which is all that survives JSON-RPC.
+func chainReadError(err error) error {
+	if strings.Contains(err.Error(), types.ErrActorNotFound.Error()) {
+		return ErrActorNotFound
+	}
+	return fmt.Errorf("%w: %w", ErrChainRead, err)
+}
+
+// ErrorCategory names the cause of a failed calculation for reports, e.g. "sector_not_found".
+// Errors without one of the Err* causes are "unknown".
+func ErrorCategory(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrInvalidAddress):
+		return "invalid_address"
+	case errors.Is(err, ErrActorNotFound):
+		return "actor_not_found"
+	case errors.Is(err, ErrUnsupportedActor):
+		return "unsupported_actor"
+	case errors.Is(err, ErrUnsupportedNetwork):
+		return "unsupported_network"
+	case errors.Is(err, ErrSectorNotFound):
+		return "sector_not_found"
+	case errors.Is(err, ErrInvalidEpoch):
+		return "invalid_epoch"
+	case errors.Is(err, ErrChainRead):
+		return "chain_read"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainReadError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		expected  error
+		retryable bool
+	}{
+		{
+			name:      "actor not found over RPC",
+			err:       errors.New("resolution lookup failed (f09999): actor not found"),
+			expected:  ErrActorNotFound,
+			retryable: false,
+		},
+		{
+			name:      "timeout",
+			err:       context.DeadlineExceeded,
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "canceled",
+			err:       context.Canceled,
+			expected:  ErrChainRead,
+			retryable: false,
+		},
+		{
+			name:      "connection failure",
+			err:       &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "connection lost over RPC",
+			err:       errors.New("websocket connection closed"),
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "connection reset",
+			err:       fmt.Errorf("read: %w", syscall.ECONNRESET),
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "truncated response",
+			err:       io.ErrUnexpectedEOF,
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "overloaded node",
+			err:       errors.New("request failed, http status 503 Service Unavailable"),
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "rate limited",
+			err:       errors.New("request failed, http status 429 Too Many Requests"),
+			expected:  ErrChainRead,
+			retryable: true,
+		},
+		{
+			name:      "decode failure",
+			err:       errors.New("failed to unmarshal sector info: cbor input had wrong number of fields"),
+			expected:  ErrChainRead,
+			retryable: false,
+		},
+		{
+			name:      "pruned state",
+			err:       errors.New("load state tree: failed to load state tree bafy2...: blockstore: block not found"),
+			expected:  ErrChainRead,
+			retryable: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &CalculationError{MinerID: "f01234", Stage: StageSectors, Err: chainReadError(tt.err)}
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+		})
+	}
+}
+
+func TestCalculationErrorMessage(t *testing.T) {
+	sector := abi.SectorNumber(7)
+	err := &CalculationError{
+		MinerID: "f01234",
+		Epoch:   100,
+		Stage:   StageSectors,
+		Sector:  &sector,
+		Err:     ErrSectorNotFound,
+	}
+	assert.Equal(t, "f01234 at epoch 100: get sectors (sector 7): sector not found", err.Error())
+
+	wrapped := fmt.Errorf("batch: %w", err)
+	var calcErr *CalculationError
+	assert.ErrorAs(t, wrapped, &calcErr)
+	assert.False(t, IsRetryable(wrapped))
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{nil, ""},
+		{&CalculationError{Stage: StageParseAddress, Err: ErrInvalidAddress}, "invalid_address"},
+		{&CalculationError{Stage: StageMinerActor, Err: chainReadError(errors.New("actor not found"))}, "actor_not_found"},
+		{&CalculationError{Stage: StageSectors, Err: ErrSectorNotFound}, "sector_not_found"},
+		{&CalculationError{Stage: StageTipSet, Err: ErrInvalidEpoch}, "invalid_epoch"},
+		{&CalculationError{Stage: StageSectors, Err: chainReadError(context.DeadlineExceeded)}, "chain_read"},
+		{&CalculationError{Stage: StageSectors, Err: chainReadError(context.Canceled)}, "canceled"},
+		{errors.New("something else"), "unknown"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, ErrorCategory(tt.err), fmt.Sprint(tt.err))
+	}
+}
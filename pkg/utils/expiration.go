@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/go-state-types/builtin/v16/util/smoothing"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+)
+
+// ExpirationComparison weighs terminating a sector at the target epoch against keeping it
+// until its expiration
+type ExpirationComparison struct {
+	SectorNumber   abi.SectorNumber
+	Status         SectorStatus
+	Expiration     abi.ChainEpoch
+	Remaining      abi.ChainEpoch // epochs until expiration
+	Fee            big.Int        // termination fee at the target epoch
+	RewardsForgone big.Int        // expected block rewards of the sector power until expiration
+	FaultPenalty   big.Int        // continued fault fees if left unproven, plus the fee of the automatic termination
+	AutoTerminated bool           // left unproven, the sector is terminated at FaultMaxAge before it expires
+	KeepNet        big.Int        // gain of keeping it proven instead, Fee + RewardsForgone before operating costs
+	UnprovenNet    big.Int        // gain of leaving it unproven instead, Fee - FaultPenalty
+}
+
+// ExpirationAnalysis compares terminating the sectors of a miner with keeping them, totals
+// sum the non-expired sectors
+type ExpirationAnalysis struct {
+	MinerID        string
+	TargetEpoch    abi.ChainEpoch
+	IsEstimate     bool
+	Projection     *ProjectionInfo // nil unless IsEstimate
+	ExpiredSectors int             // already expired at the target epoch, not compared
+	Sectors        []ExpirationComparison
+	Fee            big.Int
+	RewardsForgone big.Int
+	FaultPenalty   big.Int
+	KeepNet        big.Int
+	UnprovenNet    big.Int
+}
+
+// CompareExpiration evaluates the sectors at targetEpoch and compares terminating each of
+// them then with keeping it until expiration. Rewards are projected from the reward and
+// power estimates at targetEpoch. Left unproven, a sector pays the continued fault fee at
+// targetEpoch every proving period until it expires, or until FaultMaxAge when it is
+// terminated with the fee evaluated at that epoch. Errors are *CalculationError.
+func (s *Snapshot) CompareExpiration(targetEpoch abi.ChainEpoch, model NetworkModel) (ExpirationAnalysis, error) {
+	result, err := s.Evaluate(targetEpoch, model)
+	if err != nil {
+		return ExpirationAnalysis{}, err
+	}
+
+	// Fees of the sectors the fault cron terminates before they expire
+	autoEpoch := result.TargetEpoch + stactorsminer.FaultMaxAge
+	autoResult, err := s.Evaluate(autoEpoch, model)
+	if err != nil {
+		return ExpirationAnalysis{}, err
+	}
+	autoFees := make(map[abi.SectorNumber]big.Int, len(autoResult.SectorResults))
+	for _, sr := range autoResult.SectorResults {
+		if !sr.IsExpired {
+			autoFees[sr.SectorNumber] = sr.Fee
+		}
+	}
+
+	rewardSmoothed, powerSmoothed, _ := s.networkEstimates(result.TargetEpoch, model)
+
+	analysis := ExpirationAnalysis{
+		MinerID:        result.MinerID,
+		TargetEpoch:    result.TargetEpoch,
+		IsEstimate:     result.IsEstimate,
+		Projection:     result.Projection,
+		ExpiredSectors: result.ExpiredSectors,
+		Fee:            big.Zero(),
+		RewardsForgone: big.Zero(),
+		FaultPenalty:   big.Zero(),
+		KeepNet:        big.Zero(),
+		UnprovenNet:    big.Zero(),
+	}
+	for _, sr := range result.SectorResults {
+		if sr.IsExpired {
+			continue
+		}
+
+		remaining := sr.Expiration - result.TargetEpoch
+		rewards := expectedRewards(rewardSmoothed, powerSmoothed, sr.QAPower, remaining)
+		penalty := faultFees(sr.FaultFee, min(remaining, stactorsminer.FaultMaxAge))
+		autoFee, autoTerminated := autoFees[sr.SectorNumber]
+		if autoTerminated {
+			penalty = big.Add(penalty, autoFee)
+		}
+
+		c := ExpirationComparison{
+			SectorNumber:   sr.SectorNumber,
+			Status:         sr.Status,
+			Expiration:     sr.Expiration,
+			Remaining:      remaining,
+			Fee:            sr.Fee,
+			RewardsForgone: rewards,
+			FaultPenalty:   penalty,
+			AutoTerminated: autoTerminated,
+			KeepNet:        big.Add(sr.Fee, rewards),
+			UnprovenNet:    big.Sub(sr.Fee, penalty),
+		}
+		analysis.Sectors = append(analysis.Sectors, c)
+		analysis.Fee = big.Add(analysis.Fee, c.Fee)
+		analysis.RewardsForgone = big.Add(analysis.RewardsForgone, c.RewardsForgone)
+		analysis.FaultPenalty = big.Add(analysis.FaultPenalty, c.FaultPenalty)
+		analysis.KeepNet = big.Add(analysis.KeepNet, c.KeepNet)
+		analysis.UnprovenNet = big.Add(analysis.UnprovenNet, c.UnprovenNet)
+	}
+	return analysis, nil
+}
+
+// expectedRewards is the expected block reward of qaPower over epochs, never negative
+func expectedRewards(reward, power builtin.FilterEstimate, qaPower abi.StoragePower, epochs abi.ChainEpoch) big.Int {
+	if epochs <= 0 {
+		return big.Zero()
+	}
+	return big.Max(big.Zero(), stactorsminer.ExpectedRewardForPower(
+		smoothing.FilterEstimate(reward),
+		smoothing.FilterEstimate(power),
+		qaPower,
+		epochs,
+	))
+}
+
+// faultFees is the continued fault fee paid every proving period started within epochs
+func faultFees(faultFee big.Int, epochs abi.ChainEpoch) big.Int {
+	if epochs <= 0 {
+		return big.Zero()
+	}
+	periods := (epochs + stactorsminer.WPoStProvingPeriod - 1) / stactorsminer.WPoStProvingPeriod
+	return big.Mul(faultFee, big.NewInt(int64(periods)))
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	gobig "math/big"
+	"os"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// NetworkSample holds the smoothed reward and power estimates at a single epoch
+type NetworkSample struct {
+	Epoch  abi.ChainEpoch
+	Reward builtin.FilterEstimate
+	Power  builtin.FilterEstimate
+}
+
+// ModelFit holds projection model parameters fitted from chain history
+type ModelFit struct {
+	Model      string             `json:"model"`
+	Params     map[string]float64 `json:"params"`
+	StartEpoch abi.ChainEpoch     `json:"start_epoch"`
+	EndEpoch   abi.ChainEpoch     `json:"end_epoch"`
+	Step       abi.ChainEpoch     `json:"step"` // mean epochs between samples, null rounds shift some
+	Samples    int                `json:"samples"`
+	PowerR2    float64            `json:"power_r2"`
+	RewardR2   float64            `json:"reward_r2"`
+}
+
+// SampleNetwork samples the smoothed reward and power estimates every step epochs
+// over the lookback window ending at endEpoch (0 means current head). A sample is taken
+// at the tipset found for an epoch, the last one before a null round, and a tipset found
+// for several epochs is sampled once.
+func SampleNetwork(ctx context.Context, api ChainReader, endEpoch, lookback, step abi.ChainEpoch) ([]NetworkSample, error) {
+	if lookback <= 0 || step <= 0 {
+		return nil, fmt.Errorf("lookback and step must be positive")
+	}
+
+	adtStore := NewChainStore(ctx, api)
+
+	if endEpoch == 0 {
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current height: %v", err)
+		}
+		endEpoch = head.Height()
+	}
+
+	startEpoch := endEpoch - lookback
+	if startEpoch < 0 {
+		startEpoch = 0
+	}
+
+	samples := make([]NetworkSample, 0, lookback/step+1)
+	for epoch := startEpoch; epoch <= endEpoch; epoch += step {
+		ts, err := api.ChainGetTipSetByHeight(ctx, epoch, types.EmptyTSK)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tipset at epoch %d: %v", epoch, err)
+		}
+		if n := len(samples); n > 0 && samples[n-1].Epoch == ts.Height() {
+			continue
+		}
+
+		rewardSmoothed, powerSmoothed, err := loadNetworkEstimates(ctx, api, adtStore, ts.Key())
+		if err != nil {
+			return nil, fmt.Errorf("epoch %d: %w", epoch, err)
+		}
+
+		samples = append(samples, NetworkSample{
+			Epoch:  ts.Height(),
+			Reward: rewardSmoothed,
+			Power:  powerSmoothed,
+		})
+	}
+
+	return samples, nil
+}
+
+// FitNetworkModel fits per-epoch power growth and reward decay rates of the compound
+// model to the samples, using least squares on the logarithm of the position estimates
+func FitNetworkModel(samples []NetworkSample) (ModelFit, error) {
+	if len(samples) < 2 {
+		return ModelFit{}, fmt.Errorf("need at least 2 samples, got %d", len(samples))
+	}
+
+	epochs := make([]float64, 0, len(samples))
+	logPower := make([]float64, 0, len(samples))
+	logReward := make([]float64, 0, len(samples))
+	for _, s := range samples {
+		p, r := estimateFloat(s.Power.PositionEstimate), estimateFloat(s.Reward.PositionEstimate)
+		if p <= 0 || r <= 0 {
+			return ModelFit{}, fmt.Errorf("non-positive estimate at epoch %d", s.Epoch)
+		}
+		epochs = append(epochs, float64(s.Epoch))
+		logPower = append(logPower, math.Log(p))
+		logReward = append(logReward, math.Log(r))
+	}
+
+	powerSlope, powerR2 := linearRegression(epochs, logPower)
+	rewardSlope, rewardR2 := linearRegression(epochs, logReward)
+
+	fit := ModelFit{
+		Model: ModelCompound,
+		Params: map[string]float64{
+			ParamPowerGrowthRate: math.Exp(powerSlope) - 1,
+			ParamRewardDecayRate: 1 - math.Exp(rewardSlope),
+			ParamMinRewardFactor: DefaultMinRewardFactor,
+		},
+		StartEpoch: samples[0].Epoch,
+		EndEpoch:   samples[len(samples)-1].Epoch,
+		Step:       (samples[len(samples)-1].Epoch - samples[0].Epoch) / abi.ChainEpoch(len(samples)-1),
+		Samples:    len(samples),
+		PowerR2:    powerR2,
+		RewardR2:   rewardR2,
+	}
+
+	return fit, nil
+}
+
+// NetworkModel builds the projection model described by the fit
+func (f ModelFit) NetworkModel() (NetworkModel, error) {
+	return NewNetworkModel(f.Model, f.Params)
+}
+
+// SaveModelFit writes the fit to a JSON file
+func SaveModelFit(filename string, fit ModelFit) error {
+	data, err := json.MarshalIndent(fit, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// LoadModelFit reads a fit previously written by SaveModelFit
+func LoadModelFit(filename string) (ModelFit, error) {
+	var fit ModelFit
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fit, err
+	}
+	if err := json.Unmarshal(data, &fit); err != nil {
+		return fit, fmt.Errorf("invalid model file %s: %w", filename, err)
+	}
+	return fit, nil
+}
+
+// linearRegression returns the least squares slope of y over x and the coefficient of determination
+func linearRegression(x, y []float64) (float64, float64) {
+	n := float64(len(x))
+
+	var meanX, meanY float64
+	for i := range x {
+		meanX += x[i]
+		meanY += y[i]
+	}
+	meanX /= n
+	meanY /= n
+
+	var sxx, sxy, syy float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+
+	if sxx == 0 {
+		return 0, 0
+	}
+	slope := sxy / sxx
+
+	r2 := 1.0
+	if syy != 0 {
+		r2 = sxy * sxy / (sxx * syy)
+	}
+	return slope, r2
+}
+
+// estimateFloat converts a filter estimate value to float64, keeping its fixed point scale
+func estimateFloat(v big.Int) float64 {
+	if v.Int == nil {
+		return 0
+	}
+	f, _ := new(gobig.Float).SetInt(v.Int).Float64()
+	return f
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func syntheticSamples(powerGrowth, rewardDecay float64, count int, step abi.ChainEpoch) []NetworkSample {
+	samples := make([]NetworkSample, 0, count)
+	for i := 0; i < count; i++ {
+		epoch := abi.ChainEpoch(i) * step
+		power := 1e30 * math.Pow(1+powerGrowth, float64(epoch))
+		reward := 1e20 * math.Pow(1-rewardDecay, float64(epoch))
+
+		samples = append(samples, NetworkSample{
+			Epoch: epoch,
+			Reward: builtin.FilterEstimate{
+				PositionEstimate: scaleInt(big.NewInt(1), reward),
+				VelocityEstimate: big.Zero(),
+			},
+			Power: builtin.FilterEstimate{
+				PositionEstimate: scaleInt(big.NewInt(1), power),
+				VelocityEstimate: big.Zero(),
+			},
+		})
+	}
+	return samples
+}
+
+func TestFitNetworkModel(t *testing.T) {
+	samples := syntheticSamples(0.00002, 0.00001, 31, 2880)
+
+	fit, err := FitNetworkModel(samples)
+	require.NoError(t, err)
+
+	assert.Equal(t, ModelCompound, fit.Model)
+	assert.Equal(t, 31, fit.Samples)
+	assert.Equal(t, abi.ChainEpoch(0), fit.StartEpoch)
+	assert.Equal(t, abi.ChainEpoch(30*2880), fit.EndEpoch)
+	assert.Equal(t, abi.ChainEpoch(2880), fit.Step)
+	assert.InDelta(t, 0.00002, fit.Params[ParamPowerGrowthRate], 1e-9)
+	assert.InDelta(t, 0.00001, fit.Params[ParamRewardDecayRate], 1e-9)
+	assert.InDelta(t, 1.0, fit.PowerR2, 1e-6)
+	assert.InDelta(t, 1.0, fit.RewardR2, 1e-6)
+
+	model, err := fit.NetworkModel()
+	require.NoError(t, err)
+	assert.Equal(t, ModelCompound, model.Name())
+}
+
+func TestFitNetworkModelErrors(t *testing.T) {
+	_, err := FitNetworkModel(nil)
+	assert.Error(t, err)
+
+	samples := syntheticSamples(0.00002, 0.00001, 3, 2880)
+	samples[1].Power.PositionEstimate = big.Zero()
+	_, err = FitNetworkModel(samples)
+	assert.Error(t, err)
+}
+
+func TestModelFitSaveLoad(t *testing.T) {
+	fit, err := FitNetworkModel(syntheticSamples(0.00002, 0.00001, 5, 2880))
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "model.json")
+	require.NoError(t, SaveModelFit(filename, fit))
+
+	loaded, err := LoadModelFit(filename)
+	require.NoError(t, err)
+	assert.Equal(t, fit, loaded)
+
+	_, err = LoadModelFit(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestSampleNetworkNullRounds(t *testing.T) {
+	node := newFakeNode(t)
+
+	// The fake chain has tipsets every 100000 epochs, the epochs between are null rounds
+	samples, err := SampleNetwork(context.Background(), node, 1_000_000, 300_000, 50_000)
+	require.NoError(t, err)
+
+	epochs := make([]abi.ChainEpoch, 0, len(samples))
+	for _, s := range samples {
+		epochs = append(epochs, s.Epoch)
+	}
+	assert.Equal(t, []abi.ChainEpoch{700_000, 800_000, 900_000, 1_000_000}, epochs)
+}
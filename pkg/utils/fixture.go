@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+)
+
+// Fixture is a recorded set of chain reads. Calls are keyed by method name and JSON
+// encoded parameters, blocks read through ChainReadObj are keyed by CID.
+type Fixture struct {
+	Calls  map[string]FixtureCall `json:"calls"`
+	Blocks map[string][]byte      `json:"blocks"`
+}
+
+// FixtureCall is the recorded outcome of a single call
+type FixtureCall struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// NewFixture returns an empty fixture
+func NewFixture() *Fixture {
+	return &Fixture{
+		Calls:  make(map[string]FixtureCall),
+		Blocks: make(map[string][]byte),
+	}
+}
+
+// SaveFixture writes a fixture as indented JSON, map keys are sorted so the output is stable
+func SaveFixture(filename string, f *Fixture) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// LoadFixture reads a fixture previously written by SaveFixture
+func LoadFixture(filename string) (*Fixture, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := NewFixture()
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %w", filename, err)
+	}
+	return f, nil
+}
+
+func fixtureKey(method string, params ...interface{}) (string, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s params: %w", method, err)
+	}
+	return method + string(data), nil
+}
+
+// RecordingReader passes chain reads through to another reader and records every
+// response, including errors, into a fixture
+type RecordingReader struct {
+	r ChainReader
+
+	lk      sync.Mutex
+	fixture *Fixture
+}
+
+var _ ChainReader = (*RecordingReader)(nil)
+
+// NewRecordingReader records all reads served by r
+func NewRecordingReader(r ChainReader) *RecordingReader {
+	return &RecordingReader{r: r, fixture: NewFixture()}
+}
+
+// Fixture returns the reads recorded so far
+func (r *RecordingReader) Fixture() *Fixture {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.fixture
+}
+
+func (r *RecordingReader) record(method string, result interface{}, callErr error, params ...interface{}) error {
+	key, err := fixtureKey(method, params...)
+	if err != nil {
+		return err
+	}
+
+	var call FixtureCall
+	if callErr != nil {
+		call.Error = callErr.Error()
+	} else if call.Result, err = json.Marshal(result); err != nil {
+		return fmt.Errorf("failed to encode %s result: %w", method, err)
+	}
+
+	r.lk.Lock()
+	r.fixture.Calls[key] = call
+	r.lk.Unlock()
+	return callErr
+}
+
+func (r *RecordingReader) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	ts, err := r.r.ChainHead(ctx)
+	return ts, r.record("ChainHead", ts, err)
+}
+
+func (r *RecordingReader) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts, err := r.r.ChainGetTipSetByHeight(ctx, height, tsk)
+	return ts, r.record("ChainGetTipSetByHeight", ts, err, height, tsk)
+}
+
+func (r *RecordingReader) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, err := r.r.ChainReadObj(ctx, c)
+	if err != nil {
+		return nil, r.record("ChainReadObj", nil, err, c)
+	}
+
+	r.lk.Lock()
+	r.fixture.Blocks[c.String()] = data
+	r.lk.Unlock()
+	return data, nil
+}
+
+func (r *RecordingReader) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	has, err := r.r.ChainHasObj(ctx, c)
+	return has, r.record("ChainHasObj", has, err, c)
+}
+
+func (r *RecordingReader) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	nv, err := r.r.StateNetworkVersion(ctx, tsk)
+	return nv, r.record("StateNetworkVersion", nv, err, tsk)
+}
+
+func (r *RecordingReader) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	act, err := r.r.StateGetActor(ctx, addr, tsk)
+	return act, r.record("StateGetActor", act, err, addr, tsk)
+}
+
+func (r *RecordingReader) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	info, err := r.r.StateMinerInfo(ctx, addr, tsk)
+	return info, r.record("StateMinerInfo", info, err, addr, tsk)
+}
+
+func (r *RecordingReader) StateMinerSectors(ctx context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	sectors, err := r.r.StateMinerSectors(ctx, addr, filter, tsk)
+	return sectors, r.record("StateMinerSectors", sectors, err, addr, filter, tsk)
+}
+
+func (r *RecordingReader) StateSectorGetInfo(ctx context.Context, addr address.Address, num abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	info, err := r.r.StateSectorGetInfo(ctx, addr, num, tsk)
+	return info, r.record("StateSectorGetInfo", info, err, addr, num, tsk)
+}
+
+// ErrNotRecorded is returned by ReplayReader for reads missing from its fixture
+var ErrNotRecorded = errors.New("not recorded in fixture")
+
+// ReplayReader serves chain reads from a fixture recorded by RecordingReader
+type ReplayReader struct {
+	fixture *Fixture
+}
+
+var _ ChainReader = (*ReplayReader)(nil)
+
+// NewReplayReader serves the reads recorded in f
+func NewReplayReader(f *Fixture) *ReplayReader {
+	return &ReplayReader{fixture: f}
+}
+
+func (r *ReplayReader) replay(method string, out interface{}, params ...interface{}) error {
+	key, err := fixtureKey(method, params...)
+	if err != nil {
+		return err
+	}
+
+	call, ok := r.fixture.Calls[key]
+	if !ok {
+		return fmt.Errorf("%s: %w", key, ErrNotRecorded)
+	}
+	if call.Error != "" {
+		return errors.New(call.Error)
+	}
+	if err := json.Unmarshal(call.Result, out); err != nil {
+		return fmt.Errorf("failed to decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+func (r *ReplayReader) ChainHead(context.Context) (*types.TipSet, error) {
+	var ts *types.TipSet
+	err := r.replay("ChainHead", &ts)
+	return ts, err
+}
+
+func (r *ReplayReader) ChainGetTipSetByHeight(_ context.Context, height abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	var ts *types.TipSet
+	err := r.replay("ChainGetTipSetByHeight", &ts, height, tsk)
+	return ts, err
+}
+
+func (r *ReplayReader) ChainReadObj(_ context.Context, c cid.Cid) ([]byte, error) {
+	if data, ok := r.fixture.Blocks[c.String()]; ok {
+		return data, nil
+	}
+	var data []byte
+	err := r.replay("ChainReadObj", &data, c)
+	return data, err
+}
+
+func (r *ReplayReader) ChainHasObj(_ context.Context, c cid.Cid) (bool, error) {
+	if _, ok := r.fixture.Blocks[c.String()]; ok {
+		return true, nil
+	}
+	var has bool
+	err := r.replay("ChainHasObj", &has, c)
+	return has, err
+}
+
+func (r *ReplayReader) StateNetworkVersion(_ context.Context, tsk types.TipSetKey) (network.Version, error) {
+	var nv network.Version
+	err := r.replay("StateNetworkVersion", &nv, tsk)
+	return nv, err
+}
+
+func (r *ReplayReader) StateGetActor(_ context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	var act *types.Actor
+	err := r.replay("StateGetActor", &act, addr, tsk)
+	return act, err
+}
+
+func (r *ReplayReader) StateMinerInfo(_ context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	var info api.MinerInfo
+	err := r.replay("StateMinerInfo", &info, addr, tsk)
+	return info, err
+}
+
+func (r *ReplayReader) StateMinerSectors(_ context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	var sectors []*miner.SectorOnChainInfo
+	err := r.replay("StateMinerSectors", &sectors, addr, filter, tsk)
+	return sectors, err
+}
+
+func (r *ReplayReader) StateSectorGetInfo(_ context.Context, addr address.Address, num abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	var info *miner.SectorOnChainInfo
+	err := r.replay("StateSectorGetInfo", &info, addr, num, tsk)
+	return info, err
+}
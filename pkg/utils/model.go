@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	gobig "math/big"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+)
+
+// Built-in network projection model names
+const (
+	ModelLinear   = "linear"
+	ModelCompound = "compound"
+	ModelFilter   = "filter"
+	ModelFrozen   = "frozen"
+)
+
+// Model parameter names
+const (
+	ParamPowerGrowthRate = "power_growth_rate"
+	ParamRewardDecayRate = "reward_decay_rate"
+	ParamMinRewardFactor = "min_reward_factor"
+)
+
+// NetworkModel projects the smoothed reward and power estimates into the future
+type NetworkModel interface {
+	// Name returns the model identifier
+	Name() string
+	// Params returns the parameters the model was configured with
+	Params() map[string]float64
+	// Project returns reward and power estimates projected forward by the given number of epochs
+	Project(reward, power builtin.FilterEstimate, epochs abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate)
+}
+
+// DefaultNetworkModel returns the model used when none is specified
+func DefaultNetworkModel() NetworkModel {
+	return LinearModel{
+		PowerGrowthRate: DefaultPowerGrowthRate,
+		RewardDecayRate: DefaultRewardDecayRate,
+		MinRewardFactor: DefaultMinRewardFactor,
+	}
+}
+
+// NetworkModels returns the names of all built-in models
+func NetworkModels() []string {
+	return []string{ModelLinear, ModelCompound, ModelFilter, ModelFrozen}
+}
+
+// NewNetworkModel creates a built-in model by name, overriding its defaults with params
+func NewNetworkModel(name string, params map[string]float64) (NetworkModel, error) {
+	var allowed []string
+	switch name {
+	case ModelLinear, ModelCompound:
+		allowed = []string{ParamPowerGrowthRate, ParamRewardDecayRate, ParamMinRewardFactor}
+	case ModelFilter, ModelFrozen:
+	default:
+		return nil, fmt.Errorf("unknown network model: %s (available: %s)", name, strings.Join(NetworkModels(), ", "))
+	}
+
+	for k := range params {
+		if !slices.Contains(allowed, k) {
+			return nil, fmt.Errorf("unknown parameter %q for model %s", k, name)
+		}
+	}
+
+	for k, v := range params {
+		if err := validateModelParam(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	get := func(key string, def float64) float64 {
+		if v, ok := params[key]; ok {
+			return v
+		}
+		return def
+	}
+
+	switch name {
+	case ModelLinear:
+		return LinearModel{
+			PowerGrowthRate: get(ParamPowerGrowthRate, DefaultPowerGrowthRate),
+			RewardDecayRate: get(ParamRewardDecayRate, DefaultRewardDecayRate),
+			MinRewardFactor: get(ParamMinRewardFactor, DefaultMinRewardFactor),
+		}, nil
+	case ModelCompound:
+		return CompoundModel{
+			PowerGrowthRate: get(ParamPowerGrowthRate, DefaultPowerGrowthRate),
+			RewardDecayRate: get(ParamRewardDecayRate, DefaultRewardDecayRate),
+			MinRewardFactor: get(ParamMinRewardFactor, DefaultMinRewardFactor),
+		}, nil
+	case ModelFilter:
+		return FilterModel{}, nil
+	default:
+		return FrozenModel{}, nil
+	}
+}
+
+// validateModelParam checks that a parameter value keeps the projected estimates meaningful
+func validateModelParam(key string, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("model parameter %s must be finite, got %g", key, v)
+	}
+	switch key {
+	case ParamPowerGrowthRate:
+		if v < -1 {
+			return fmt.Errorf("model parameter %s must be at least -1, got %g", key, v)
+		}
+	case ParamRewardDecayRate:
+		if v > 1 {
+			return fmt.Errorf("model parameter %s must be at most 1, got %g", key, v)
+		}
+	case ParamMinRewardFactor:
+		if v < 0 || v > 1 {
+			return fmt.Errorf("model parameter %s must be between 0 and 1, got %g", key, v)
+		}
+	}
+	return nil
+}
+
+// ParseModelParams parses model parameters in key=value,key=value format
+func ParseModelParams(paramsStr string) (map[string]float64, error) {
+	params := make(map[string]float64)
+
+	for _, part := range strings.Split(paramsStr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid model parameter format: %s", part)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		value, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for model parameter %s: %s", key, kv[1])
+		}
+		params[key] = value
+	}
+
+	return params, nil
+}
+
+// FormatModelParams formats model parameters as key=value pairs sorted by key
+func FormatModelParams(params map[string]float64) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, params[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// LinearModel grows power and decays reward linearly with the projection window
+type LinearModel struct {
+	PowerGrowthRate float64 // growth per epoch
+	RewardDecayRate float64 // decay per epoch
+	MinRewardFactor float64 // reward never drops below this fraction
+}
+
+func (m LinearModel) Name() string { return ModelLinear }
+
+func (m LinearModel) Params() map[string]float64 {
+	return map[string]float64{
+		ParamPowerGrowthRate: m.PowerGrowthRate,
+		ParamRewardDecayRate: m.RewardDecayRate,
+		ParamMinRewardFactor: m.MinRewardFactor,
+	}
+}
+
+func (m LinearModel) Project(reward, power builtin.FilterEstimate, epochs abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate) {
+	if epochs <= 0 {
+		return reward, power
+	}
+
+	growthFactor := 1.0 + m.PowerGrowthRate*float64(epochs)
+	decayFactor := math.Max(1.0-m.RewardDecayRate*float64(epochs), m.MinRewardFactor)
+
+	return scaleEstimate(reward, decayFactor), scaleEstimate(power, growthFactor)
+}
+
+// CompoundModel grows power and decays reward exponentially, compounding every epoch
+type CompoundModel struct {
+	PowerGrowthRate float64 // growth per epoch
+	RewardDecayRate float64 // decay per epoch
+	MinRewardFactor float64 // reward never drops below this fraction
+}
+
+func (m CompoundModel) Name() string { return ModelCompound }
+
+func (m CompoundModel) Params() map[string]float64 {
+	return map[string]float64{
+		ParamPowerGrowthRate: m.PowerGrowthRate,
+		ParamRewardDecayRate: m.RewardDecayRate,
+		ParamMinRewardFactor: m.MinRewardFactor,
+	}
+}
+
+func (m CompoundModel) Project(reward, power builtin.FilterEstimate, epochs abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate) {
+	if epochs <= 0 {
+		return reward, power
+	}
+
+	growthFactor := math.Pow(1.0+m.PowerGrowthRate, float64(epochs))
+	decayFactor := math.Max(math.Pow(1.0-m.RewardDecayRate, float64(epochs)), m.MinRewardFactor)
+
+	return scaleEstimate(reward, decayFactor), scaleEstimate(power, growthFactor)
+}
+
+// FilterModel extrapolates the alpha-beta filters kept by the reward and power actors:
+// position + velocity * epochs, with the velocity held constant
+type FilterModel struct{}
+
+func (m FilterModel) Name() string { return ModelFilter }
+
+func (m FilterModel) Params() map[string]float64 { return map[string]float64{} }
+
+func (m FilterModel) Project(reward, power builtin.FilterEstimate, epochs abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate) {
+	if epochs <= 0 {
+		return reward, power
+	}
+	return extrapolateEstimate(reward, epochs), extrapolateEstimate(power, epochs)
+}
+
+// FrozenModel keeps the network parameters unchanged
+type FrozenModel struct{}
+
+func (m FrozenModel) Name() string { return ModelFrozen }
+
+func (m FrozenModel) Params() map[string]float64 { return map[string]float64{} }
+
+func (m FrozenModel) Project(reward, power builtin.FilterEstimate, _ abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate) {
+	return reward, power
+}
+
+// scaleEstimate multiplies both position and velocity of a filter estimate by factor, the
+// position never drops below zero
+func scaleEstimate(est builtin.FilterEstimate, factor float64) builtin.FilterEstimate {
+	return builtin.FilterEstimate{
+		PositionEstimate: big.Max(scaleInt(est.PositionEstimate, factor), big.Zero()),
+		VelocityEstimate: scaleInt(est.VelocityEstimate, factor),
+	}
+}
+
+// extrapolateEstimate moves the position along the velocity, never below zero
+func extrapolateEstimate(est builtin.FilterEstimate, epochs abi.ChainEpoch) builtin.FilterEstimate {
+	pos := big.Add(est.PositionEstimate, big.Mul(est.VelocityEstimate, big.NewInt(int64(epochs))))
+	return builtin.FilterEstimate{
+		PositionEstimate: big.Max(pos, big.Zero()),
+		VelocityEstimate: est.VelocityEstimate,
+	}
+}
+
+// scaleInt multiplies v by factor without losing the precision of v. The factor is clamped
+// to [0, math.MaxFloat64], a NaN factor yields zero.
+func scaleInt(v big.Int, factor float64) big.Int {
+	if v.Int == nil {
+		return v
+	}
+	switch {
+	case math.IsNaN(factor) || factor <= 0:
+		return big.Zero()
+	case math.IsInf(factor, 1):
+		factor = math.MaxFloat64
+	}
+	f := new(gobig.Float).SetPrec(256).SetInt(v.Int)
+	f.Mul(f, new(gobig.Float).SetFloat64(factor))
+	i, _ := f.Int(nil)
+	return big.NewFromGo(i)
+}
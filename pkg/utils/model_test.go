@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNetworkModel(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		params   map[string]float64
+		expected NetworkModel
+		wantErr  bool
+	}{
+		{
+			name:     "linear defaults",
+			model:    ModelLinear,
+			expected: DefaultNetworkModel(),
+		},
+		{
+			name:   "compound with params",
+			model:  ModelCompound,
+			params: map[string]float64{ParamPowerGrowthRate: 0.001},
+			expected: CompoundModel{
+				PowerGrowthRate: 0.001,
+				RewardDecayRate: DefaultRewardDecayRate,
+				MinRewardFactor: DefaultMinRewardFactor,
+			},
+		},
+		{
+			name:     "filter",
+			model:    ModelFilter,
+			expected: FilterModel{},
+		},
+		{
+			name:     "frozen",
+			model:    ModelFrozen,
+			expected: FrozenModel{},
+		},
+		{
+			name:    "unknown model",
+			model:   "quadratic",
+			wantErr: true,
+		},
+		{
+			name:    "unknown parameter",
+			model:   ModelLinear,
+			params:  map[string]float64{"growth": 0.1},
+			wantErr: true,
+		},
+		{
+			name:    "parameter not accepted by model",
+			model:   ModelFrozen,
+			params:  map[string]float64{ParamPowerGrowthRate: 0.1},
+			wantErr: true,
+		},
+		{
+			name:    "power shrinking faster than it exists",
+			model:   ModelLinear,
+			params:  map[string]float64{ParamPowerGrowthRate: -1.5},
+			wantErr: true,
+		},
+		{
+			name:    "reward decaying past zero",
+			model:   ModelCompound,
+			params:  map[string]float64{ParamRewardDecayRate: 2},
+			wantErr: true,
+		},
+		{
+			name:    "reward floor above one",
+			model:   ModelLinear,
+			params:  map[string]float64{ParamMinRewardFactor: 5},
+			wantErr: true,
+		},
+		{
+			name:    "negative reward floor",
+			model:   ModelCompound,
+			params:  map[string]float64{ParamMinRewardFactor: -0.1},
+			wantErr: true,
+		},
+		{
+			name:    "infinite rate",
+			model:   ModelCompound,
+			params:  map[string]float64{ParamPowerGrowthRate: math.Inf(1)},
+			wantErr: true,
+		},
+		{
+			name:    "NaN rate",
+			model:   ModelLinear,
+			params:  map[string]float64{ParamRewardDecayRate: math.NaN()},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := NewNetworkModel(tt.model, tt.params)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, model)
+		})
+	}
+}
+
+func TestParseModelParams(t *testing.T) {
+	params, err := ParseModelParams("power_growth_rate=0.0002, reward_decay_rate = 1e-5,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		ParamPowerGrowthRate: 0.0002,
+		ParamRewardDecayRate: 0.00001,
+	}, params)
+
+	params, err = ParseModelParams("")
+	require.NoError(t, err)
+	assert.Empty(t, params)
+
+	_, err = ParseModelParams("power_growth_rate")
+	assert.Error(t, err)
+
+	_, err = ParseModelParams("power_growth_rate=fast")
+	assert.Error(t, err)
+}
+
+func TestNetworkModelProject(t *testing.T) {
+	reward := builtin.FilterEstimate{
+		PositionEstimate: big.NewInt(1_000_000),
+		VelocityEstimate: big.NewInt(-10),
+	}
+	power := builtin.FilterEstimate{
+		PositionEstimate: big.NewInt(2_000_000),
+		VelocityEstimate: big.NewInt(20),
+	}
+	epochs := abi.ChainEpoch(1000)
+
+	tests := []struct {
+		name           string
+		model          NetworkModel
+		expectedReward builtin.FilterEstimate
+		expectedPower  builtin.FilterEstimate
+	}{
+		{
+			name:  "linear",
+			model: LinearModel{PowerGrowthRate: 0.001, RewardDecayRate: 0.0005, MinRewardFactor: 0.1},
+			expectedReward: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(500_000),
+				VelocityEstimate: big.NewInt(-5),
+			},
+			expectedPower: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(4_000_000),
+				VelocityEstimate: big.NewInt(40),
+			},
+		},
+		{
+			name:  "linear reward floor",
+			model: LinearModel{PowerGrowthRate: 0, RewardDecayRate: 0.01, MinRewardFactor: 0.25},
+			expectedReward: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(250_000),
+				VelocityEstimate: big.NewInt(-2),
+			},
+			expectedPower: power,
+		},
+		{
+			name:  "compound",
+			model: CompoundModel{PowerGrowthRate: 0.0001, RewardDecayRate: 0.0001, MinRewardFactor: 0.1},
+			expectedReward: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(904_832),
+				VelocityEstimate: big.NewInt(-9),
+			},
+			expectedPower: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(2_210_330),
+				VelocityEstimate: big.NewInt(22),
+			},
+		},
+		{
+			name:  "filter",
+			model: FilterModel{},
+			expectedReward: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(990_000),
+				VelocityEstimate: big.NewInt(-10),
+			},
+			expectedPower: builtin.FilterEstimate{
+				PositionEstimate: big.NewInt(2_020_000),
+				VelocityEstimate: big.NewInt(20),
+			},
+		},
+		{
+			name:           "frozen",
+			model:          FrozenModel{},
+			expectedReward: reward,
+			expectedPower:  power,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, p := tt.model.Project(reward, power, epochs)
+			assert.Equal(t, tt.expectedReward.PositionEstimate, r.PositionEstimate)
+			assert.Equal(t, tt.expectedReward.VelocityEstimate, r.VelocityEstimate)
+			assert.Equal(t, tt.expectedPower.PositionEstimate, p.PositionEstimate)
+			assert.Equal(t, tt.expectedPower.VelocityEstimate, p.VelocityEstimate)
+
+			// No projection window leaves the estimates untouched
+			r, p = tt.model.Project(reward, power, 0)
+			assert.Equal(t, reward, r)
+			assert.Equal(t, power, p)
+		})
+	}
+}
+
+func TestFilterModelNeverNegative(t *testing.T) {
+	reward := builtin.FilterEstimate{
+		PositionEstimate: big.NewInt(100),
+		VelocityEstimate: big.NewInt(-1),
+	}
+
+	r, _ := FilterModel{}.Project(reward, reward, 1000)
+	assert.True(t, r.PositionEstimate.IsZero())
+}
+
+func TestNetworkModelProjectExtremes(t *testing.T) {
+	reward := builtin.FilterEstimate{
+		PositionEstimate: big.NewInt(1_000_000),
+		VelocityEstimate: big.NewInt(-10),
+	}
+	power := builtin.FilterEstimate{
+		PositionEstimate: big.NewInt(2_000_000),
+		VelocityEstimate: big.NewInt(20),
+	}
+
+	// Growth overflowing float64 is clamped instead of panicking
+	_, p := CompoundModel{PowerGrowthRate: 0.001, MinRewardFactor: 0.1}.Project(reward, power, 1_000_000)
+	assert.Equal(t, 1, p.PositionEstimate.Sign())
+
+	// Shrinking power stops at zero
+	_, p = LinearModel{PowerGrowthRate: -0.01}.Project(reward, power, 1000)
+	assert.True(t, p.PositionEstimate.IsZero())
+	assert.True(t, p.VelocityEstimate.IsZero())
+}
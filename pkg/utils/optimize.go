@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+)
+
+// refineSteps is how many finer steps a search step is split into around the best epoch
+const refineSteps = 8
+
+// HoldMode is how the sectors are held until they are terminated
+type HoldMode string
+
+const (
+	HoldProven   HoldMode = "proven"   // keep proving, the expected block rewards lower the cost
+	HoldUnproven HoldMode = "unproven" // stop proving, the continued fault fees add to the cost
+)
+
+// HoldModes returns the names of all hold modes
+func HoldModes() []string {
+	return []string{string(HoldProven), string(HoldUnproven)}
+}
+
+// TerminationOptimum is the result of FindBestTerminationEpoch. The cost of an epoch is the
+// termination fee there plus the holding cost of the sectors from the snapshot epoch until
+// then or their expiration, whichever comes first.
+type TerminationOptimum struct {
+	Hold      HoldMode
+	End       abi.ChainEpoch    // last epoch searched
+	Today     CalculationResult // terminating at the snapshot epoch, its cost is the fee
+	Best      CalculationResult // lowest cost within the horizon, the earliest of equal costs
+	BestCost  big.Int           // Best.TotalFee + Holding
+	Holding   big.Int           // holding cost until the best epoch, negative for rewards earned
+	Evaluated int               // epochs evaluated by the search
+}
+
+// FindBestTerminationEpoch searches the epochs from the snapshot epoch to horizon epochs
+// later for the one where holding and then terminating the sectors costs the least,
+// projecting the network with model. Held proven, a sector earns the expected block rewards
+// until then, before operating costs. Held unproven, it pays the continued fault fee every
+// proving period, and the horizon is cut at FaultMaxAge when the remaining sectors are
+// terminated automatically. Sectors expired by an epoch cost no termination fee there.
+// The cost is evaluated every step epochs and at the last sector expiration within each
+// step, then around the best epoch with finer steps down to a single epoch. Errors are
+// *CalculationError.
+func (s *Snapshot) FindBestTerminationEpoch(horizon, step abi.ChainEpoch, model NetworkModel, hold HoldMode) (TerminationOptimum, error) {
+	if horizon < 0 || step <= 0 {
+		return TerminationOptimum{}, fmt.Errorf("invalid search range: horizon %d, step %d", horizon, step)
+	}
+	if hold != HoldProven && hold != HoldUnproven {
+		return TerminationOptimum{}, fmt.Errorf("unknown hold mode: %s", hold)
+	}
+
+	start, end := s.epoch, s.epoch+horizon
+	if hold == HoldUnproven {
+		end = min(end, start+stactorsminer.FaultMaxAge)
+	}
+
+	today, err := s.Evaluate(start, model)
+	if err != nil {
+		return TerminationOptimum{}, err
+	}
+	opt := TerminationOptimum{
+		Hold:      hold,
+		End:       end,
+		Today:     today,
+		Best:      today,
+		BestCost:  today.TotalFee,
+		Holding:   big.Zero(),
+		Evaluated: 1,
+	}
+
+	holding, err := s.newHoldingCosts(today, end, step, model, hold)
+	if err != nil {
+		return TerminationOptimum{}, err
+	}
+
+	evaluate := func(epoch abi.ChainEpoch) error {
+		result, err := s.Evaluate(epoch, model)
+		if err != nil {
+			return err
+		}
+		opt.Evaluated++
+		held, err := holding.at(epoch)
+		if err != nil {
+			return err
+		}
+		cost := big.Add(result.TotalFee, held)
+		if cost.LessThan(opt.BestCost) || (cost.Equals(opt.BestCost) && epoch < opt.Best.TargetEpoch) {
+			opt.Best, opt.BestCost, opt.Holding = result, cost, held
+		}
+		return nil
+	}
+
+	// The fee drops when sectors expire, so the cost is not unimodal and the grid alone can
+	// step over the drops. The last expiration within a step has the most sectors expired.
+	var candidates []abi.ChainEpoch
+	for epoch := start + step; epoch <= end; epoch += step {
+		candidates = append(candidates, epoch)
+	}
+	candidates = append(candidates, end)
+	lastExpiration := make(map[abi.ChainEpoch]abi.ChainEpoch)
+	for _, sr := range holding.sectors {
+		if sr.Expiration <= end {
+			lastExpiration[(sr.Expiration-start)/step] = sr.Expiration
+		}
+	}
+	for _, epoch := range lastExpiration {
+		candidates = append(candidates, epoch)
+	}
+	slices.Sort(candidates)
+	for _, epoch := range slices.Compact(candidates) {
+		if epoch <= start {
+			continue
+		}
+		if err := evaluate(epoch); err != nil {
+			return TerminationOptimum{}, err
+		}
+	}
+
+	for step > 1 {
+		lo, hi := max(start, opt.Best.TargetEpoch-step), min(end, opt.Best.TargetEpoch+step)
+		step = max(1, step/refineSteps)
+		for epoch := lo; epoch <= hi; epoch += step {
+			if err := evaluate(epoch); err != nil {
+				return TerminationOptimum{}, err
+			}
+		}
+	}
+	return opt, nil
+}
+
+// holdingCosts is the cost of holding the sectors from the snapshot epoch. The network is
+// projected with the model to the start of every step, as Evaluate does for the fee, and
+// those estimates apply until the next step. The cost until every step is summed up front,
+// so the cost at an epoch only adds the sectors expiring since the last step.
+type holdingCosts struct {
+	s           *Snapshot
+	hold        HoldMode
+	start, step abi.ChainEpoch
+	sectors     []SectorResult              // held sectors by expiration
+	qaFrom      []abi.StoragePower          // qaFrom[i] is the power of sectors[i:]
+	estimates   [][2]builtin.FilterEstimate // reward and power at every step
+	steps       []big.Int                   // cost until every step
+}
+
+func (s *Snapshot) newHoldingCosts(today CalculationResult, end, step abi.ChainEpoch, model NetworkModel, hold HoldMode) (*holdingCosts, error) {
+	h := &holdingCosts{s: s, hold: hold, start: s.epoch, step: step}
+	for _, sr := range today.SectorResults {
+		if !sr.IsExpired {
+			h.sectors = append(h.sectors, sr)
+		}
+	}
+	slices.SortFunc(h.sectors, func(a, b SectorResult) int { return cmp.Compare(a.Expiration, b.Expiration) })
+
+	h.qaFrom = make([]abi.StoragePower, len(h.sectors)+1)
+	h.qaFrom[len(h.sectors)] = big.Zero()
+	for i := len(h.sectors) - 1; i >= 0; i-- {
+		h.qaFrom[i] = big.Add(h.qaFrom[i+1], h.sectors[i].QAPower)
+	}
+
+	h.steps = []big.Int{big.Zero()}
+	for epoch := h.start; epoch <= end; epoch += step {
+		reward, power, _ := s.networkEstimates(epoch, model)
+		h.estimates = append(h.estimates, [2]builtin.FilterEstimate{reward, power})
+		if epoch+step > end {
+			break
+		}
+		k := len(h.steps) - 1
+		cost, err := h.sinceStep(k, epoch+step)
+		if err != nil {
+			return nil, err
+		}
+		h.steps = append(h.steps, big.Add(h.steps[k], cost))
+	}
+	return h, nil
+}
+
+// at returns the holding cost from the snapshot epoch until epoch
+func (h *holdingCosts) at(epoch abi.ChainEpoch) (big.Int, error) {
+	k := int((epoch - h.start) / h.step)
+	cost, err := h.sinceStep(k, epoch)
+	if err != nil {
+		return big.Int{}, err
+	}
+	return big.Add(h.steps[k], cost), nil
+}
+
+// sinceStep returns the holding cost from step k until epoch, at most a step later
+func (h *holdingCosts) sinceStep(k int, epoch abi.ChainEpoch) (big.Int, error) {
+	from := h.start + abi.ChainEpoch(k)*h.step
+	lo := sort.Search(len(h.sectors), func(i int) bool { return h.sectors[i].Expiration > from })
+	hi := sort.Search(len(h.sectors), func(i int) bool { return h.sectors[i].Expiration > epoch })
+
+	total := big.Zero()
+	for _, sr := range h.sectors[lo:hi] {
+		cost, err := h.cost(k, sr.QAPower, from, sr.Expiration)
+		if err != nil {
+			return big.Int{}, err
+		}
+		total = big.Add(total, cost)
+	}
+	cost, err := h.cost(k, h.qaFrom[hi], from, epoch)
+	if err != nil {
+		return big.Int{}, err
+	}
+	return big.Add(total, cost), nil
+}
+
+// cost returns the holding cost of qaPower from one epoch until another within step k
+func (h *holdingCosts) cost(k int, qaPower abi.StoragePower, from, to abi.ChainEpoch) (big.Int, error) {
+	if to <= from || qaPower.IsZero() {
+		return big.Zero(), nil
+	}
+	reward, power := h.estimates[k][0], h.estimates[k][1]
+	if h.hold == HoldProven {
+		return big.Sub(big.Zero(), expectedRewards(reward, power, qaPower, to-from)), nil
+	}
+
+	faultFee, err := miner.PledgePenaltyForContinuedFault(h.s.networkVersion, reward, power, qaPower)
+	if err != nil {
+		return big.Int{}, h.s.sectorError(to, StageFaultFee, h.sectors[0].SectorNumber, err)
+	}
+	// Fault fees are paid at the start of every proving period counted from the snapshot
+	return big.Sub(faultFees(faultFee, to-h.start), faultFees(faultFee, from-h.start)), nil
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	stactors "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/big"
+	stbuiltin "github.com/filecoin-project/go-state-types/builtin"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/go-state-types/builtin/v16/util/smoothing"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+)
+
+// Generations of the termination fee formula, selected by the actors version of the network
+const (
+	FormulaRewardCap70  = "reward-70d"  // actors v0: BR(20d) plus up to 70 days of BR(1d), at least SP(t)
+	FormulaRewardCap140 = "reward-140d" // actors v2 to v15: BR(20d) plus half of up to 140 days of BR(1d), at least SP(t)
+	FormulaPledge       = "pledge"      // actors v16 (FIP-0098): share of initial pledge, at least a fault fee multiple
+)
+
+// Parameters of the reward based formulas, from specs-actors and builtin-actors before v16
+const (
+	terminationLifetimeCapV0 = abi.ChainEpoch(70) * stbuiltin.EpochsInDay
+	terminationLifetimeCapV2 = abi.ChainEpoch(140) * stbuiltin.EpochsInDay
+
+	// SP(t) projection periods, 5 days at network version 0 and 3.5 days afterwards
+	terminationLowerBoundPeriodV0 = abi.ChainEpoch(stbuiltin.EpochsInDay * 50 / 10)
+	terminationLowerBoundPeriodV1 = abi.ChainEpoch(stbuiltin.EpochsInDay * 35 / 10)
+)
+
+// terminationFormula returns the formula generation in effect at a network version
+func terminationFormula(nv network.Version) (string, error) {
+	v, err := stactors.VersionForNetwork(nv)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case v == stactors.Version0:
+		return FormulaRewardCap70, nil
+	case v < stactors.Version16:
+		return FormulaRewardCap140, nil
+	case v == stactors.Version16:
+		return FormulaPledge, nil
+	default:
+		return "", fmt.Errorf("no termination fee formula for actors version %d", v)
+	}
+}
+
+// sectorPowerBaseEpoch returns the epoch the sector power was last set at. Lotus leaves
+// PowerBaseEpoch unset for miner actors before v12, where it was the activation epoch.
+func sectorPowerBaseEpoch(sector *miner.SectorOnChainInfo) abi.ChainEpoch {
+	if sector.PowerBaseEpoch < sector.Activation {
+		return sector.Activation
+	}
+	return sector.PowerBaseEpoch
+}
+
+// sectorQAPower returns the quality adjusted power of a sector
+func sectorQAPower(sectorSize abi.SectorSize, sector *miner.SectorOnChainInfo) abi.StoragePower {
+	duration := sector.Expiration - sectorPowerBaseEpoch(sector)
+	return stactorsminer.QAPowerForWeight(sectorSize, duration, sector.VerifiedDealWeight)
+}
+
+// rewardTerminationFee is PledgePenaltyForTermination of actors v0 to v15 along with the
+// term that set the fee:
+//
+//	max(SP(t), BR(StartEpoch, 20d) + BR(StartEpoch, 1d) * min(SectorAge, 140d) / 2)
+//
+// SectorAge counts from the power base epoch and the rest of the cap is filled with the
+// day reward of the sector replaced by a capacity upgrade. Actors v0 used
+// min(SectorAge/2, 70d) instead, without halving the age at network version 0.
+//
+// Lotus does not read ReplacedDayReward and ReplacedSectorAge from miner actors before v12,
+// so the fee of a capacity upgraded sector younger than the cap is understated there, see
+// replacedRewardUnknown.
+func rewardTerminationFee(nv network.Version, sector *miner.SectorOnChainInfo, targetEpoch abi.ChainEpoch,
+	qaPower abi.StoragePower, rewardSmoothed, powerSmoothed builtin.FilterEstimate) (abi.TokenAmount, string) {
+	baseEpoch := sectorPowerBaseEpoch(sector)
+	sectorAge := targetEpoch - baseEpoch
+	lowerBoundPeriod := terminationLowerBoundPeriodV1
+
+	var lifetimeCap, cappedAge abi.ChainEpoch
+	var penalizedReward abi.TokenAmount
+	if v, _ := stactors.VersionForNetwork(nv); v == stactors.Version0 {
+		if nv == network.Version0 {
+			lowerBoundPeriod = terminationLowerBoundPeriodV0
+		} else {
+			sectorAge /= 2
+		}
+		lifetimeCap = terminationLifetimeCapV0
+		cappedAge = min(sectorAge, lifetimeCap)
+		penalizedReward = big.Div(
+			big.Mul(tokenOrZero(sector.ExpectedDayReward), big.NewInt(int64(cappedAge))),
+			big.NewInt(int64(stbuiltin.EpochsInDay)))
+	} else {
+		lifetimeCap = terminationLifetimeCapV2
+		cappedAge = min(sectorAge, lifetimeCap)
+		expectedReward := big.Mul(tokenOrZero(sector.ExpectedDayReward), big.NewInt(int64(cappedAge)))
+
+		replacedAge := min(baseEpoch-sector.Activation, lifetimeCap-cappedAge)
+		expectedReward = big.Add(expectedReward, big.Mul(tokenOrZero(sector.ReplacedDayReward), big.NewInt(int64(replacedAge))))
+
+		penalizedReward = big.Div(expectedReward, big.NewInt(int64(stbuiltin.EpochsInDay)*2))
+	}
+	rewardFee := big.Add(tokenOrZero(sector.ExpectedStoragePledge), penalizedReward)
+
+	lowerBound := stactorsminer.ExpectedRewardForPower(
+		smoothing.FilterEstimate(rewardSmoothed),
+		smoothing.FilterEstimate(powerSmoothed),
+		qaPower,
+		lowerBoundPeriod,
+	)
+
+	if lowerBound.GreaterThan(rewardFee) {
+		return lowerBound, TermLowerBound
+	}
+	if cappedAge >= lifetimeCap {
+		return rewardFee, TermRewardCap
+	}
+	return rewardFee, TermAge
+}
+
+// replacedRewardUnknown reports whether the reward termination fee of the sector may miss
+// the replaced sector term: Lotus leaves the replaced fields zero for miner actors v2 to v11,
+// which matters only while the sector age is below the lifetime cap
+func replacedRewardUnknown(nv network.Version, sector *miner.SectorOnChainInfo, targetEpoch abi.ChainEpoch) bool {
+	v, err := stactors.VersionForNetwork(nv)
+	if err != nil || v == stactors.Version0 || v >= stactors.Version12 {
+		return false
+	}
+	return tokenOrZero(sector.ReplacedDayReward).Sign() == 0 && targetEpoch-sectorPowerBaseEpoch(sector) < terminationLifetimeCapV2
+}
+
+func tokenOrZero(amount *abi.TokenAmount) abi.TokenAmount {
+	if amount == nil || amount.Nil() {
+		return big.Zero()
+	}
+	return *amount
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminationFormula(t *testing.T) {
+	tests := []struct {
+		nv       network.Version
+		expected string
+	}{
+		{nv: network.Version0, expected: FormulaRewardCap70},
+		{nv: network.Version3, expected: FormulaRewardCap70},
+		{nv: network.Version4, expected: FormulaRewardCap140},
+		{nv: network.Version21, expected: FormulaRewardCap140},
+		{nv: network.Version24, expected: FormulaRewardCap140},
+		{nv: network.Version25, expected: FormulaPledge},
+		{nv: network.Version26, expected: FormulaPledge},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("nv%d", tt.nv), func(t *testing.T) {
+			formula, err := terminationFormula(tt.nv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, formula)
+		})
+	}
+}
+
+func TestRewardTerminationFee(t *testing.T) {
+	const day = abi.ChainEpoch(2880)
+	token := func(v int64) *abi.TokenAmount {
+		amount := big.NewInt(v)
+		return &amount
+	}
+
+	// Network estimates that make SP(t) negligible next to the sector rewards
+	rewardSmoothed := builtin.FilterEstimate{PositionEstimate: big.Lsh(big.NewInt(1<<40), 128), VelocityEstimate: big.Zero()}
+	powerSmoothed := builtin.FilterEstimate{PositionEstimate: big.Lsh(big.NewInt(1<<50), 128), VelocityEstimate: big.Zero()}
+	qaPower := big.NewInt(32 << 30)
+
+	tests := []struct {
+		name         string
+		nv           network.Version
+		sector       miner.SectorOnChainInfo
+		targetEpoch  abi.ChainEpoch
+		expectedFee  abi.TokenAmount
+		expectedTerm string
+	}{
+		{
+			name: "age below cap",
+			nv:   network.Version21,
+			sector: miner.SectorOnChainInfo{
+				Activation:            100 * day,
+				PowerBaseEpoch:        100 * day,
+				ExpectedDayReward:     token(1e16),
+				ExpectedStoragePledge: token(2e17),
+			},
+			targetEpoch:  110 * day,
+			expectedFee:  big.NewInt(2.5e17), // 20 day reward + 10 days * day reward / 2
+			expectedTerm: TermAge,
+		},
+		{
+			name: "age above cap",
+			nv:   network.Version21,
+			sector: miner.SectorOnChainInfo{
+				Activation:            100 * day,
+				ExpectedDayReward:     token(1e16),
+				ExpectedStoragePledge: token(2e17),
+			},
+			targetEpoch:  300 * day,
+			expectedFee:  big.NewInt(9e17), // 20 day reward + 140 days * day reward / 2
+			expectedTerm: TermRewardCap,
+		},
+		{
+			name: "replaced sector fills the cap",
+			nv:   network.Version21,
+			sector: miner.SectorOnChainInfo{
+				Activation:            0,
+				PowerBaseEpoch:        100 * day,
+				ExpectedDayReward:     token(1e16),
+				ExpectedStoragePledge: token(2e17),
+				ReplacedDayReward:     token(2e16),
+			},
+			targetEpoch:  140 * day,
+			expectedFee:  big.NewInt(1.4e18), // 20 day reward + (40 days * day reward + 100 days * replaced day reward) / 2
+			expectedTerm: TermAge,
+		},
+		{
+			name: "actors v0 halves age",
+			nv:   network.Version3,
+			sector: miner.SectorOnChainInfo{
+				Activation:            0,
+				ExpectedDayReward:     token(1e16),
+				ExpectedStoragePledge: token(2e17),
+			},
+			targetEpoch:  100 * day,
+			expectedFee:  big.NewInt(7e17), // 20 day reward + 50 days * day reward
+			expectedTerm: TermAge,
+		},
+		{
+			name: "network version 0 caps at 70 days",
+			nv:   network.Version0,
+			sector: miner.SectorOnChainInfo{
+				Activation:            0,
+				ExpectedDayReward:     token(1e16),
+				ExpectedStoragePledge: token(2e17),
+			},
+			targetEpoch:  100 * day,
+			expectedFee:  big.NewInt(9e17), // 20 day reward + 70 days * day reward
+			expectedTerm: TermRewardCap,
+		},
+		{
+			name:         "lower bound without recorded rewards",
+			nv:           network.Version21,
+			sector:       miner.SectorOnChainInfo{Activation: 0},
+			targetEpoch:  10 * day,
+			expectedFee:  big.NewInt(10080 << 25), // 3.5 days of reward for the sector share of power
+			expectedTerm: TermLowerBound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fee, term := rewardTerminationFee(tt.nv, &tt.sector, tt.targetEpoch, qaPower, rewardSmoothed, powerSmoothed)
+			assert.Equal(t, tt.expectedFee.String(), fee.String())
+			assert.Equal(t, tt.expectedTerm, term)
+		})
+	}
+}
+
+func TestReplacedRewardUnknown(t *testing.T) {
+	const day = abi.ChainEpoch(2880)
+	replaced := big.NewInt(1e16)
+
+	tests := []struct {
+		name     string
+		nv       network.Version
+		sector   miner.SectorOnChainInfo
+		expected bool
+	}{
+		{name: "actors v11 below cap", nv: network.Version20, sector: miner.SectorOnChainInfo{Activation: 100 * day}, expected: true},
+		{name: "actors v2 below cap", nv: network.Version4, sector: miner.SectorOnChainInfo{Activation: 100 * day}, expected: true},
+		{name: "actors v11 above cap", nv: network.Version20, sector: miner.SectorOnChainInfo{Activation: 0}},
+		{name: "actors v11 replaced reward known", nv: network.Version20, sector: miner.SectorOnChainInfo{Activation: 100 * day, ReplacedDayReward: &replaced}},
+		{name: "actors v0", nv: network.Version3, sector: miner.SectorOnChainInfo{Activation: 100 * day}},
+		{name: "actors v12", nv: network.Version21, sector: miner.SectorOnChainInfo{Activation: 100 * day}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, replacedRewardUnknown(tt.nv, &tt.sector, 200*day))
+		})
+	}
+}
+
+func TestSectorQAPowerBeforePowerBaseEpoch(t *testing.T) {
+	sector := &miner.SectorOnChainInfo{
+		Activation:         1000,
+		Expiration:         2000,
+		VerifiedDealWeight: big.NewInt(1000 * (32 << 30)),
+	}
+
+	// Without a power base epoch the power counts from activation, fully verified is 10x
+	assert.Equal(t, big.NewInt(10*(32<<30)), sectorQAPower(abi.SectorSize(32<<30), sector))
+}
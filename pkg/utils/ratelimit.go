@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+)
+
+// RateLimitedReader spaces out the reads of another reader so that at most a fixed number
+// of calls start per second, shared by all goroutines using it
+type RateLimitedReader struct {
+	r        ChainReader
+	interval time.Duration
+
+	lk   sync.Mutex
+	next time.Time
+}
+
+var _ ChainReader = (*RateLimitedReader)(nil)
+
+// NewRateLimitedReader limits r to rps calls per second, rps <= 0 returns r unchanged
+func NewRateLimitedReader(r ChainReader, rps float64) ChainReader {
+	if rps <= 0 {
+		return r
+	}
+	return &RateLimitedReader{r: r, interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// wait reserves the next call slot and sleeps until it starts
+func (r *RateLimitedReader) wait(ctx context.Context) error {
+	r.lk.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.lk.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *RateLimitedReader) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.ChainHead(ctx)
+}
+
+func (r *RateLimitedReader) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.ChainGetTipSetByHeight(ctx, height, tsk)
+}
+
+func (r *RateLimitedReader) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.ChainReadObj(ctx, c)
+}
+
+func (r *RateLimitedReader) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	if err := r.wait(ctx); err != nil {
+		return false, err
+	}
+	return r.r.ChainHasObj(ctx, c)
+}
+
+func (r *RateLimitedReader) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	if err := r.wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.r.StateNetworkVersion(ctx, tsk)
+}
+
+func (r *RateLimitedReader) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.StateGetActor(ctx, addr, tsk)
+}
+
+func (r *RateLimitedReader) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return api.MinerInfo{}, err
+	}
+	return r.r.StateMinerInfo(ctx, addr, tsk)
+}
+
+func (r *RateLimitedReader) StateMinerSectors(ctx context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.StateMinerSectors(ctx, addr, filter, tsk)
+}
+
+func (r *RateLimitedReader) StateSectorGetInfo(ctx context.Context, addr address.Address, num abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.r.StateSectorGetInfo(ctx, addr, num, tsk)
+}
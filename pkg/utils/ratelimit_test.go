@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headReader answers ChainHead only and records when each call arrived
+type headReader struct {
+	ChainReader
+
+	lk    sync.Mutex
+	calls []time.Time
+}
+
+func (r *headReader) ChainHead(context.Context) (*types.TipSet, error) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.calls = append(r.calls, time.Now())
+	return nil, nil
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	inner := &headReader{}
+	assert.Same(t, inner, NewRateLimitedReader(inner, 0))
+
+	r := NewRateLimitedReader(inner, 50) // one call every 20ms
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.ChainHead(context.Background())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, inner.calls, 5)
+	// The first call starts immediately, the last one after four intervals
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func TestRateLimitedReaderCanceled(t *testing.T) {
+	inner := &headReader{}
+	r := NewRateLimitedReader(inner, 0.1) // one call every 10s
+
+	_, err := r.ChainHead(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.ChainHead(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Len(t, inner.calls, 1)
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+)
+
+// RetryPolicy controls how RetryingReader retries failed chain reads
+type RetryPolicy struct {
+	MaxAttempts    int           // attempts per call including the first, <= 1 disables retries
+	InitialBackoff time.Duration // delay before the first retry, doubled after every retry
+	MaxBackoff     time.Duration // upper bound of the delay, 0 means no bound
+	CallTimeout    time.Duration // deadline of a single attempt, 0 means no deadline
+}
+
+// DefaultRetryPolicy retries a call up to 3 times, starting with a one second backoff
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		CallTimeout:    10 * time.Minute,
+	}
+}
+
+// backoff returns the delay before the given retry (1 for the first), with up to 50% jitter
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RetryStats counts the retries made by RetryingReader for calls with a context from
+// WithRetryStats
+type RetryStats struct {
+	retries atomic.Int64
+}
+
+// Retries returns the number of retries made so far
+func (s *RetryStats) Retries() int {
+	return int(s.retries.Load())
+}
+
+type retryStatsKey struct{}
+
+// WithRetryStats returns a context that counts the retries of the reads made with it
+func WithRetryStats(ctx context.Context) (context.Context, *RetryStats) {
+	stats := &RetryStats{}
+	return context.WithValue(ctx, retryStatsKey{}, stats), stats
+}
+
+// RetryingReader retries the transient failures of another reader, see IsRetryable.
+// Failures like a missing actor are returned right away.
+type RetryingReader struct {
+	r      ChainReader
+	policy RetryPolicy
+}
+
+var _ ChainReader = (*RetryingReader)(nil)
+
+// NewRetryingReader retries the reads of r according to policy, r is returned unchanged
+// if the policy neither retries nor limits calls
+func NewRetryingReader(r ChainReader, policy RetryPolicy) ChainReader {
+	if policy.MaxAttempts <= 1 && policy.CallTimeout <= 0 {
+		return r
+	}
+	return &RetryingReader{r: r, policy: policy}
+}
+
+// retry runs call until it succeeds, fails permanently or runs out of attempts
+func retry[T any](ctx context.Context, p RetryPolicy, call func(context.Context) (T, error)) (T, error) {
+	stats, _ := ctx.Value(retryStatsKey{}).(*RetryStats)
+	for attempt := 1; ; attempt++ {
+		res, err := callWithTimeout(ctx, p.CallTimeout, call)
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !IsRetryable(chainReadError(err)) {
+			return res, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+		if stats != nil {
+			stats.retries.Add(1)
+		}
+	}
+}
+
+func callWithTimeout[T any](ctx context.Context, timeout time.Duration, call func(context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return call(ctx)
+}
+
+func (r *RetryingReader) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (*types.TipSet, error) {
+		return r.r.ChainHead(ctx)
+	})
+}
+
+func (r *RetryingReader) ChainGetTipSetByHeight(ctx context.Context, height abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (*types.TipSet, error) {
+		return r.r.ChainGetTipSetByHeight(ctx, height, tsk)
+	})
+}
+
+func (r *RetryingReader) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) ([]byte, error) {
+		return r.r.ChainReadObj(ctx, c)
+	})
+}
+
+func (r *RetryingReader) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (bool, error) {
+		return r.r.ChainHasObj(ctx, c)
+	})
+}
+
+func (r *RetryingReader) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (network.Version, error) {
+		return r.r.StateNetworkVersion(ctx, tsk)
+	})
+}
+
+func (r *RetryingReader) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (*types.Actor, error) {
+		return r.r.StateGetActor(ctx, addr, tsk)
+	})
+}
+
+func (r *RetryingReader) StateMinerInfo(ctx context.Context, addr address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (api.MinerInfo, error) {
+		return r.r.StateMinerInfo(ctx, addr, tsk)
+	})
+}
+
+func (r *RetryingReader) StateMinerSectors(ctx context.Context, addr address.Address, filter *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) ([]*miner.SectorOnChainInfo, error) {
+		return r.r.StateMinerSectors(ctx, addr, filter, tsk)
+	})
+}
+
+func (r *RetryingReader) StateSectorGetInfo(ctx context.Context, addr address.Address, num abi.SectorNumber, tsk types.TipSetKey) (*miner.SectorOnChainInfo, error) {
+	return retry(ctx, r.policy, func(ctx context.Context) (*miner.SectorOnChainInfo, error) {
+		return r.r.StateSectorGetInfo(ctx, addr, num, tsk)
+	})
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyReader fails StateGetActor with the queued errors before succeeding
+type flakyReader struct {
+	ChainReader
+
+	errs  []error
+	calls int
+}
+
+func (r *flakyReader) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	r.calls++
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return nil, err
+	}
+	return &types.Actor{}, nil
+}
+
+func TestRetryingReader(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	timeout := errors.New("i/o timeout")
+
+	tests := []struct {
+		name    string
+		errs    []error
+		calls   int
+		retries int
+		wantErr bool
+	}{
+		{name: "no failure", calls: 1},
+		{name: "transient failure", errs: []error{timeout, timeout}, calls: 3, retries: 2},
+		{name: "out of attempts", errs: []error{timeout, timeout, timeout}, calls: 3, retries: 2, wantErr: true},
+		{name: "actor not found", errs: []error{errors.New("resolution lookup failed (f09999): actor not found")}, calls: 1, wantErr: true},
+		{name: "deterministic failure", errs: []error{errors.New("decoding sector info: cbor input had wrong number of fields")}, calls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &flakyReader{errs: tt.errs}
+			r := NewRetryingReader(inner, policy)
+
+			ctx, stats := WithRetryStats(context.Background())
+			_, err := r.StateGetActor(ctx, address.Undef, types.EmptyTSK)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.calls, inner.calls)
+			assert.Equal(t, tt.retries, stats.Retries())
+		})
+	}
+}
+
+// slowReader blocks ChainHead until the call is canceled
+type slowReader struct {
+	ChainReader
+	calls int
+}
+
+func (r *slowReader) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	r.calls++
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRetryingReaderCallTimeout(t *testing.T) {
+	inner := &slowReader{}
+	r := NewRetryingReader(inner, RetryPolicy{MaxAttempts: 2, CallTimeout: 10 * time.Millisecond})
+
+	_, err := r.ChainHead(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second} {
+		d := p.backoff(retry)
+		require.GreaterOrEqual(t, d, max/2, "retry %d", retry)
+		require.LessOrEqual(t, d, max, "retry %d", retry)
+	}
+
+	inner := &flakyReader{}
+	assert.Same(t, ChainReader(inner), NewRetryingReader(inner, RetryPolicy{MaxAttempts: 1}))
+}
@@ -0,0 +1,388 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Snapshot holds everything needed to calculate termination fees of a miner, loaded
+// from a single tipset. It is never modified after loading, so it can be evaluated
+// at many target epochs and shared between goroutines without further RPC calls.
+type Snapshot struct {
+	minerID        string
+	epoch          abi.ChainEpoch
+	currentEpoch   abi.ChainEpoch
+	networkVersion network.Version
+	sectorSize     abi.SectorSize
+	owner          address.Address
+	worker         address.Address
+	beneficiary    address.Address
+	sectors        []*miner.SectorOnChainInfo
+	locations      map[abi.SectorNumber]sectorLocation
+	periodStart    abi.ChainEpoch // proving period start, see deadlineIsMutable
+	rewardSmoothed builtin.FilterEstimate
+	powerSmoothed  builtin.FilterEstimate
+}
+
+// MinerID returns the miner the snapshot was loaded for
+func (s *Snapshot) MinerID() string { return s.minerID }
+
+// Epoch returns the epoch the snapshot state was loaded at
+func (s *Snapshot) Epoch() abi.ChainEpoch { return s.epoch }
+
+// CurrentEpoch returns the chain head height at load time
+func (s *Snapshot) CurrentEpoch() abi.ChainEpoch { return s.currentEpoch }
+
+// NetworkVersion returns the network version at the snapshot epoch
+func (s *Snapshot) NetworkVersion() network.Version { return s.networkVersion }
+
+// Owner returns the owner address of the miner
+func (s *Snapshot) Owner() address.Address { return s.owner }
+
+// Worker returns the worker address of the miner
+func (s *Snapshot) Worker() address.Address { return s.worker }
+
+// Beneficiary returns the beneficiary address of the miner, the owner before beneficiaries
+// were introduced in network version 17
+func (s *Snapshot) Beneficiary() address.Address { return s.beneficiary }
+
+// NumSectors returns the number of sectors in the snapshot
+func (s *Snapshot) NumSectors() int { return len(s.sectors) }
+
+// Filter returns a snapshot with only the sectors matching f, sectors not found in any
+// partition are dropped
+func (s *Snapshot) Filter(f PartitionFilter) *Snapshot {
+	filtered := *s
+	filtered.sectors = nil
+	for _, sector := range s.sectors {
+		if loc, ok := s.locations[sector.SectorNumber]; ok && f.Matches(loc.deadline, loc.partition) {
+			filtered.sectors = append(filtered.sectors, sector)
+		}
+	}
+	return &filtered
+}
+
+// WithEpoch returns the snapshot for a later past epoch that resolves to the same tipset,
+// e.g. an epoch in null rounds, sharing the loaded state
+func (s *Snapshot) WithEpoch(epoch abi.ChainEpoch) *Snapshot {
+	moved := *s
+	moved.epoch = epoch
+	return &moved
+}
+
+// LoadSnapshot loads the miner sectors, network version and smoothed estimates.
+// Past target epochs load the state at that epoch, future or zero target epochs load
+// the current head. Errors are *CalculationError.
+func LoadSnapshot(ctx context.Context, api ChainReader, minerID string, targetEpoch abi.ChainEpoch, sectorNumbers []abi.SectorNumber) (*Snapshot, error) {
+	snap := &Snapshot{minerID: minerID}
+	fail := func(stage Stage, err error) *CalculationError {
+		return &CalculationError{MinerID: minerID, Epoch: targetEpoch, Stage: stage, Err: err}
+	}
+
+	// Parse miner address
+	mid, err := address.NewFromString(minerID)
+	if err != nil {
+		return nil, fail(StageParseAddress, fmt.Errorf("%w: %v", ErrInvalidAddress, err))
+	}
+
+	adtStore := NewChainStore(ctx, api)
+
+	// Get current tipset
+	currentTs, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, fail(StageChainHead, chainReadError(err))
+	}
+	snap.currentEpoch = currentTs.Height()
+
+	var ts *types.TipSet
+	if targetEpoch == 0 || targetEpoch >= currentTs.Height() {
+		// Current or future epoch, use current data
+		ts = currentTs
+		snap.epoch = currentTs.Height()
+	} else {
+		// Historical data, get actual tipset
+		ts, err = api.ChainGetTipSetByHeight(ctx, targetEpoch, types.EmptyTSK)
+		if err != nil {
+			return nil, fail(StageTipSet, chainReadError(err))
+		}
+		snap.epoch = targetEpoch
+	}
+
+	snap.networkVersion, err = api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return nil, fail(StageNetworkVersion, chainReadError(err))
+	}
+
+	minerAct, err := api.StateGetActor(ctx, mid, ts.Key())
+	if err != nil {
+		return nil, fail(StageMinerActor, chainReadError(err))
+	}
+
+	minerInfo, err := api.StateMinerInfo(ctx, mid, ts.Key())
+	if err != nil {
+		return nil, fail(StageMinerInfo, chainReadError(err))
+	}
+	snap.sectorSize = minerInfo.SectorSize
+	snap.owner = minerInfo.Owner
+	snap.worker = minerInfo.Worker
+	snap.beneficiary = minerInfo.Beneficiary
+	if snap.beneficiary == address.Undef {
+		snap.beneficiary = minerInfo.Owner
+	}
+
+	if name, _, ok := actors.GetActorMetaByCode(minerAct.Code); !ok || name != manifest.MinerKey {
+		return nil, fail(StageMinerActor, fmt.Errorf("%w: actor code %s", ErrUnsupportedActor, minerAct.Code))
+	}
+
+	// Get sectors
+	if len(sectorNumbers) == 0 {
+		// Get all sectors
+		snap.sectors, err = api.StateMinerSectors(ctx, mid, nil, ts.Key())
+		if err != nil {
+			return nil, fail(StageSectors, chainReadError(err))
+		}
+	} else {
+		// Get specific sectors
+		for _, num := range sectorNumbers {
+			info, err := api.StateSectorGetInfo(ctx, mid, num, ts.Key())
+			if err != nil {
+				err = chainReadError(err)
+			} else if info == nil {
+				err = ErrSectorNotFound
+			}
+			if err != nil {
+				calcErr := fail(StageSectors, err)
+				calcErr.Sector = &num
+				return nil, calcErr
+			}
+			snap.sectors = append(snap.sectors, info)
+		}
+	}
+
+	snap.locations, snap.periodStart, err = loadSectorLocations(adtStore, minerAct, snap.sectors)
+	if err != nil {
+		return nil, fail(StageSectorStatus, chainReadError(err))
+	}
+
+	// Get network parameters
+	snap.rewardSmoothed, snap.powerSmoothed, err = loadNetworkEstimates(ctx, api, adtStore, ts.Key())
+	if err != nil {
+		return nil, fail(StageNetworkEstimates, chainReadError(err))
+	}
+
+	return snap, nil
+}
+
+// Evaluate calculates termination fees at the target epoch (0 means the snapshot epoch).
+// Target epochs after the snapshot epoch project the network parameters forward with
+// model (nil means DefaultNetworkModel). Target epochs before it are rejected with
+// ErrInvalidEpoch, load a snapshot at that epoch instead. Sectors keep their status at
+// the snapshot epoch, terminated sectors are left out. Errors are *CalculationError.
+func (s *Snapshot) Evaluate(targetEpoch abi.ChainEpoch, model NetworkModel) (CalculationResult, error) {
+	if targetEpoch == 0 {
+		targetEpoch = s.epoch
+	}
+	if targetEpoch < s.epoch {
+		return CalculationResult{}, &CalculationError{
+			MinerID: s.minerID,
+			Epoch:   targetEpoch,
+			Stage:   StageTipSet,
+			Err:     fmt.Errorf("%w: before snapshot epoch %d", ErrInvalidEpoch, s.epoch),
+		}
+	}
+
+	result := CalculationResult{
+		MinerID:      s.minerID,
+		TargetEpoch:  targetEpoch,
+		CurrentEpoch: s.currentEpoch,
+		IsEstimate:   targetEpoch > s.epoch,
+	}
+
+	formula, err := terminationFormula(s.networkVersion)
+	if err != nil {
+		return CalculationResult{}, &CalculationError{
+			MinerID: s.minerID,
+			Epoch:   targetEpoch,
+			Stage:   StageTerminationFee,
+			Err:     fmt.Errorf("%w: %v", ErrUnsupportedNetwork, err),
+		}
+	}
+	result.Formula = formula
+
+	rewardSmoothed, powerSmoothed, projection := s.networkEstimates(targetEpoch, model)
+	result.Projection = projection
+
+	// Calculate fees
+	totalFee := big.Zero()
+	totalPledge := big.Zero()
+	expiredSectors := 0
+	sectorResults := make([]SectorResult, 0, len(s.sectors))
+	statusTotals := make(map[SectorStatus]*StatusTotal)
+	partitionTotals := make(map[[2]uint64]*PartitionTotal)
+
+	for _, sector := range s.sectors {
+		loc, ok := s.locations[sector.SectorNumber]
+		if !ok {
+			loc.status = SectorUnknown
+		}
+		status := loc.status
+
+		sectorResult := SectorResult{
+			SectorNumber:  sector.SectorNumber,
+			Status:        status,
+			Deadline:      loc.deadline,
+			Partition:     loc.partition,
+			Activation:    sector.Activation,
+			Expiration:    sector.Expiration,
+			InitialPledge: sector.InitialPledge,
+		}
+
+		// Check if sector has expired at target epoch
+		if targetEpoch >= sector.Expiration {
+			expiredSectors++
+			sectorResult.IsExpired = true
+			sectorResult.ExpiredDays = EpochsToDays(targetEpoch - sector.Expiration)
+			sectorResults = append(sectorResults, sectorResult)
+			continue
+		}
+
+		// Only sectors terminated before their expiration are left out as terminated
+		if status == SectorTerminated {
+			result.TerminatedSectors++
+			continue
+		}
+
+		// Calculate sector age
+		sectorAge := targetEpoch - sector.Activation
+		sectorResult.Age = sectorAge
+		sectorResult.QAPower = sectorQAPower(s.sectorSize, sector)
+
+		faultFee, err := miner.PledgePenaltyForContinuedFault(
+			s.networkVersion,
+			rewardSmoothed,
+			powerSmoothed,
+			sectorResult.QAPower,
+		)
+		if err != nil {
+			return CalculationResult{}, s.sectorError(targetEpoch, StageFaultFee, sector.SectorNumber, err)
+		}
+
+		var fee abi.TokenAmount
+		if formula == FormulaPledge {
+			fee, err = miner.PledgePenaltyForTermination(s.networkVersion, sector.InitialPledge, sectorAge, faultFee)
+			if err != nil {
+				return CalculationResult{}, s.sectorError(targetEpoch, StageTerminationFee, sector.SectorNumber, err)
+			}
+			sectorResult.BindingTerm = terminationFeeTerm(sector.InitialPledge, sectorAge, faultFee)
+		} else {
+			fee, sectorResult.BindingTerm = rewardTerminationFee(s.networkVersion, sector, targetEpoch,
+				sectorResult.QAPower, rewardSmoothed, powerSmoothed)
+			if replacedRewardUnknown(s.networkVersion, sector, targetEpoch) {
+				sectorResult.Approximate = true
+				result.ApproximateSectors++
+			}
+		}
+
+		sectorResult.Fee = fee
+		sectorResult.FaultFee = faultFee
+		totalFee = big.Add(totalFee, fee)
+		totalPledge = big.Add(totalPledge, sector.InitialPledge)
+		sectorResults = append(sectorResults, sectorResult)
+
+		st := statusTotals[status]
+		if st == nil {
+			st = &StatusTotal{Status: status, Fee: big.Zero(), InitialPledge: big.Zero()}
+			statusTotals[status] = st
+		}
+		st.Sectors++
+		st.Fee = big.Add(st.Fee, fee)
+		st.InitialPledge = big.Add(st.InitialPledge, sector.InitialPledge)
+
+		if !ok {
+			continue
+		}
+		key := [2]uint64{loc.deadline, loc.partition}
+		pt := partitionTotals[key]
+		if pt == nil {
+			pt = &PartitionTotal{
+				Deadline:      loc.deadline,
+				Partition:     loc.partition,
+				Mutable:       deadlineIsMutable(s.periodStart, loc.deadline, s.epoch),
+				Fee:           big.Zero(),
+				InitialPledge: big.Zero(),
+			}
+			partitionTotals[key] = pt
+		}
+		pt.Sectors++
+		pt.Fee = big.Add(pt.Fee, fee)
+		pt.InitialPledge = big.Add(pt.InitialPledge, sector.InitialPledge)
+	}
+
+	for _, pt := range partitionTotals {
+		result.PartitionTotals = append(result.PartitionTotals, *pt)
+	}
+	sort.Slice(result.PartitionTotals, func(i, j int) bool {
+		a, b := result.PartitionTotals[i], result.PartitionTotals[j]
+		if a.Deadline != b.Deadline {
+			return a.Deadline < b.Deadline
+		}
+		return a.Partition < b.Partition
+	})
+
+	for _, status := range SectorStatuses() {
+		if st := statusTotals[status]; st != nil {
+			result.StatusTotals = append(result.StatusTotals, *st)
+		}
+	}
+
+	result.TotalSectors = len(sectorResults)
+	result.ExpiredSectors = expiredSectors
+	result.ActiveSectors = result.TotalSectors - expiredSectors
+	result.TotalFee = totalFee
+	result.TotalPledge = totalPledge
+	result.SectorResults = sectorResults
+
+	return result, nil
+}
+
+// networkEstimates returns the reward and power estimates at targetEpoch, projected forward
+// with model after the snapshot epoch. The projection is nil if nothing was projected.
+func (s *Snapshot) networkEstimates(targetEpoch abi.ChainEpoch, model NetworkModel) (builtin.FilterEstimate, builtin.FilterEstimate, *ProjectionInfo) {
+	if targetEpoch <= s.epoch {
+		return s.rewardSmoothed, s.powerSmoothed, nil
+	}
+	if model == nil {
+		model = DefaultNetworkModel()
+	}
+
+	projectionEpochs := targetEpoch - s.epoch
+	rewardSmoothed, powerSmoothed := model.Project(s.rewardSmoothed, s.powerSmoothed, projectionEpochs)
+	return rewardSmoothed, powerSmoothed, &ProjectionInfo{
+		Model:  model.Name(),
+		Epochs: projectionEpochs,
+		Params: model.Params(),
+	}
+}
+
+// sectorError reports a failed fee calculation, the miner penalty helpers only fail on
+// network versions they do not support
+func (s *Snapshot) sectorError(targetEpoch abi.ChainEpoch, stage Stage, sector abi.SectorNumber, err error) error {
+	return &CalculationError{
+		MinerID: s.minerID,
+		Epoch:   targetEpoch,
+		Stage:   stage,
+		Sector:  &sector,
+		Err:     fmt.Errorf("%w: %v", ErrUnsupportedNetwork, err),
+	}
+}
@@ -0,0 +1,411 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSnapshot() *Snapshot {
+	pledge := big.Mul(big.NewInt(1e18), big.NewInt(2))
+	return &Snapshot{
+		minerID:        "f01234",
+		epoch:          1_000_000,
+		currentEpoch:   1_000_000,
+		networkVersion: network.Version25,
+		sectorSize:     abi.SectorSize(32 << 30),
+		sectors: []*miner.SectorOnChainInfo{
+			{
+				SectorNumber:   1,
+				Activation:     500_000,
+				Expiration:     1_500_000,
+				PowerBaseEpoch: 500_000,
+				InitialPledge:  pledge,
+			},
+			{
+				SectorNumber:   2,
+				Activation:     100_000,
+				Expiration:     900_000,
+				PowerBaseEpoch: 100_000,
+				InitialPledge:  pledge,
+			},
+		},
+		rewardSmoothed: builtin.FilterEstimate{
+			PositionEstimate: big.Lsh(big.NewInt(5e18), 128),
+			VelocityEstimate: big.Zero(),
+		},
+		powerSmoothed: builtin.FilterEstimate{
+			PositionEstimate: big.Lsh(big.NewInt(1<<60), 128),
+			VelocityEstimate: big.Zero(),
+		},
+	}
+}
+
+func TestSnapshotEvaluate(t *testing.T) {
+	snap := testSnapshot()
+
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, abi.ChainEpoch(1_000_000), result.TargetEpoch)
+	assert.False(t, result.IsEstimate)
+	assert.Equal(t, FormulaPledge, result.Formula)
+	assert.Nil(t, result.Projection)
+	assert.Equal(t, 2, result.TotalSectors)
+	assert.Equal(t, 1, result.ActiveSectors)
+	assert.Equal(t, 1, result.ExpiredSectors)
+
+	active := result.SectorResults[0]
+	assert.Equal(t, abi.ChainEpoch(500_000), active.Age)
+	assert.Equal(t, TermPledgeCap, active.BindingTerm)
+	assert.Equal(t, result.TotalFee, active.Fee)
+	assert.Equal(t, snap.sectors[0].InitialPledge, result.TotalPledge)
+
+	expired := result.SectorResults[1]
+	assert.True(t, expired.IsExpired)
+	assert.Equal(t, EpochsToDays(100_000), expired.ExpiredDays)
+}
+
+func TestSnapshotEvaluateApproximate(t *testing.T) {
+	snap := testSnapshot()
+	snap.sectors[0].Activation, snap.sectors[0].PowerBaseEpoch = 900_000, 900_000
+
+	// Miner actors v11 state in Lotus lacks the replaced sector of a capacity upgrade
+	snap.networkVersion = network.Version20
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, FormulaRewardCap140, result.Formula)
+	assert.Equal(t, 1, result.ApproximateSectors)
+	assert.True(t, result.SectorResults[0].Approximate)
+	assert.False(t, result.SectorResults[1].Approximate)
+
+	snap.networkVersion = network.Version21
+	result, err = snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.Zero(t, result.ApproximateSectors)
+	assert.False(t, result.SectorResults[0].Approximate)
+}
+
+func TestSnapshotEvaluateEstimate(t *testing.T) {
+	snap := testSnapshot()
+
+	result, err := snap.Evaluate(1_100_000, FrozenModel{})
+	require.NoError(t, err)
+	assert.True(t, result.IsEstimate)
+	require.NotNil(t, result.Projection)
+	assert.Equal(t, ModelFrozen, result.Projection.Model)
+	assert.Equal(t, abi.ChainEpoch(100_000), result.Projection.Epochs)
+
+	// Nil model falls back to the default model
+	result, err = snap.Evaluate(1_100_000, nil)
+	require.NoError(t, err)
+	require.NotNil(t, result.Projection)
+	assert.Equal(t, ModelLinear, result.Projection.Model)
+
+	// Past the last expiration nothing is left to terminate
+	result, err = snap.Evaluate(1_500_000, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ActiveSectors)
+	assert.True(t, result.TotalFee.IsZero())
+}
+
+func TestSnapshotEvaluateIsRepeatable(t *testing.T) {
+	snap := testSnapshot()
+
+	first, err := snap.Evaluate(1_200_000, nil)
+	require.NoError(t, err)
+	_, err = snap.Evaluate(1_400_000, CompoundModel{PowerGrowthRate: 0.001})
+	require.NoError(t, err)
+	second, err := snap.Evaluate(1_200_000, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+}
+
+func TestSnapshotEvaluateBeforeSnapshot(t *testing.T) {
+	snap := testSnapshot()
+
+	_, err := snap.Evaluate(900_000, nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidEpoch)
+	assert.False(t, IsRetryable(err))
+}
+
+func TestSnapshotEvaluateUnsupportedNetwork(t *testing.T) {
+	snap := testSnapshot()
+	snap.networkVersion = network.Version(99)
+
+	_, err := snap.Evaluate(0, nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrUnsupportedNetwork)
+
+	var calcErr *CalculationError
+	require.ErrorAs(t, err, &calcErr)
+	assert.Equal(t, StageTerminationFee, calcErr.Stage)
+	assert.Nil(t, calcErr.Sector)
+}
+
+func TestSnapshotEvaluateRewardFormula(t *testing.T) {
+	snap := testSnapshot()
+	snap.networkVersion = network.Version21
+	dayReward, storagePledge := big.NewInt(1e16), big.NewInt(2e17)
+	snap.sectors[0].ExpectedDayReward = &dayReward
+	snap.sectors[0].ExpectedStoragePledge = &storagePledge
+
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, FormulaRewardCap140, result.Formula)
+
+	// Sector 1 is older than the 140 day cap: 20 day reward + 140 days * day reward / 2
+	assert.Equal(t, TermRewardCap, result.SectorResults[0].BindingTerm)
+	assert.Equal(t, big.NewInt(9e17), result.SectorResults[0].Fee)
+}
+
+func TestLoadSnapshotMinerAddresses(t *testing.T) {
+	snap, err := LoadSnapshot(context.Background(), newFakeNode(t), fakeMinerID, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "f0100", snap.Owner().String())
+	assert.Equal(t, "f0101", snap.Worker().String())
+	assert.Equal(t, "f0100", snap.Beneficiary().String())
+}
+
+func TestSnapshotEvaluateSectorStatus(t *testing.T) {
+	snap := testSnapshot()
+	terminated := *snap.sectors[0]
+	terminated.SectorNumber = 3
+	snap.sectors = append(snap.sectors, &terminated)
+	snap.locations = map[abi.SectorNumber]sectorLocation{
+		1: {status: SectorFaulty},
+		2: {status: SectorActive},
+		3: {status: SectorTerminated},
+	}
+
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.TotalSectors)
+	assert.Equal(t, 1, result.TerminatedSectors)
+	require.Len(t, result.SectorResults, 2)
+	assert.Equal(t, SectorFaulty, result.SectorResults[0].Status)
+	assert.Equal(t, SectorActive, result.SectorResults[1].Status)
+
+	// The expired sector is not part of the totals
+	require.Len(t, result.StatusTotals, 1)
+	assert.Equal(t, SectorFaulty, result.StatusTotals[0].Status)
+	assert.Equal(t, 1, result.StatusTotals[0].Sectors)
+	assert.Equal(t, result.TotalFee, result.StatusTotals[0].Fee)
+}
+
+func TestSnapshotWithEpoch(t *testing.T) {
+	snap := testSnapshot()
+	snap.currentEpoch = 1500000
+	moved := snap.WithEpoch(1200000)
+	assert.EqualValues(t, 1000000, snap.Epoch())
+	assert.EqualValues(t, 1200000, moved.Epoch())
+
+	// The moved snapshot evaluates its epoch as state, not as an estimate
+	result, err := moved.Evaluate(0, nil)
+	require.NoError(t, err)
+	assert.False(t, result.IsEstimate)
+	_, err = moved.Evaluate(1100000, nil)
+	assert.ErrorIs(t, err, ErrInvalidEpoch)
+}
+
+func TestSnapshotEvaluateExpiredTerminatedSector(t *testing.T) {
+	snap := testSnapshot()
+	snap.locations = map[abi.SectorNumber]sectorLocation{
+		1: {status: SectorTerminated},
+		2: {status: SectorActive},
+	}
+
+	// Sector 1 expires at 1500000, it is left out as terminated before and counted as expired after
+	result, err := snap.Evaluate(1200000, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.TerminatedSectors)
+	assert.Equal(t, 1, result.ExpiredSectors)
+	require.Len(t, result.SectorResults, 1)
+
+	result, err = snap.Evaluate(1600000, nil)
+	require.NoError(t, err)
+	assert.Zero(t, result.TerminatedSectors)
+	assert.Equal(t, 2, result.ExpiredSectors)
+	require.Len(t, result.SectorResults, 2)
+	assert.True(t, result.SectorResults[0].IsExpired)
+	assert.Zero(t, result.TotalFee.Sign())
+}
+
+func TestSnapshotPartitions(t *testing.T) {
+	snap, err := LoadSnapshot(context.Background(), newFakeNode(t), fakeMinerID, 0, nil)
+	require.NoError(t, err)
+
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	require.Len(t, result.PartitionTotals, 2)
+
+	// Sector 3 in deadline 0 is expired, deadline 5 is in its challenge window at the head
+	dl0, dl5 := result.PartitionTotals[0], result.PartitionTotals[1]
+	assert.Equal(t, PartitionTotal{Deadline: 0, Partition: 0, Mutable: true, Sectors: 1, Fee: dl0.Fee, InitialPledge: dl0.InitialPledge}, dl0)
+	assert.Equal(t, PartitionTotal{Deadline: 5, Partition: 0, Mutable: false, Sectors: 2, Fee: dl5.Fee, InitialPledge: dl5.InitialPledge}, dl5)
+	assert.Equal(t, result.TotalFee, big.Add(dl0.Fee, dl5.Fee))
+
+	filtered, err := snap.Filter(PartitionFilter{Deadline: 5}).Evaluate(0, nil)
+	require.NoError(t, err)
+	require.Len(t, filtered.SectorResults, 2)
+	assert.Equal(t, abi.SectorNumber(2), filtered.SectorResults[0].SectorNumber)
+	assert.Equal(t, abi.SectorNumber(4), filtered.SectorResults[1].SectorNumber)
+	assert.Equal(t, dl5.Fee, filtered.TotalFee)
+
+	partition := uint64(1)
+	assert.Zero(t, snap.Filter(PartitionFilter{Deadline: 0, Partition: &partition}).NumSectors())
+}
+
+func TestDeadlineIsMutable(t *testing.T) {
+	// Deadline 5 of a period starting at 0 is open from 300 to 360
+	for _, tc := range []struct {
+		epoch   abi.ChainEpoch
+		mutable bool
+	}{
+		{epoch: 239, mutable: true},
+		{epoch: 240, mutable: false}, // one challenge window before opening
+		{epoch: 359, mutable: false},
+		{epoch: 360, mutable: true}, // next opening is a period later
+	} {
+		assert.Equal(t, tc.mutable, deadlineIsMutable(0, 5, tc.epoch), "epoch %d", tc.epoch)
+	}
+}
+
+func TestSnapshotCompareExpiration(t *testing.T) {
+	snap := testSnapshot()
+	snap.sectors = append(snap.sectors, &miner.SectorOnChainInfo{
+		SectorNumber:   3,
+		Activation:     900_000,
+		Expiration:     1_000_000 + 10*builtin.EpochsInDay + 1,
+		PowerBaseEpoch: 900_000,
+		InitialPledge:  big.NewInt(1e18),
+	})
+
+	analysis, err := snap.CompareExpiration(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, analysis.ExpiredSectors)
+	require.Len(t, analysis.Sectors, 2)
+
+	result, err := snap.Evaluate(0, nil)
+	require.NoError(t, err)
+	later, err := snap.Evaluate(1_000_000+stactorsminer.FaultMaxAge, nil)
+	require.NoError(t, err)
+
+	// Sector 1 outlives the fault max age: 42 days of fault fees, then terminated
+	long := analysis.Sectors[0]
+	assert.True(t, long.AutoTerminated)
+	assert.Equal(t, result.SectorResults[0].Fee, long.Fee)
+	assert.Equal(t, big.Add(big.Mul(result.SectorResults[0].FaultFee, big.NewInt(42)), later.SectorResults[0].Fee), long.FaultPenalty)
+	assert.True(t, long.RewardsForgone.GreaterThan(big.Zero()))
+	assert.Equal(t, big.Add(long.Fee, long.RewardsForgone), long.KeepNet)
+	assert.Equal(t, big.Sub(long.Fee, long.FaultPenalty), long.UnprovenNet)
+
+	// Sector 3 expires within 11 proving periods, rewards scale with the remaining lifetime
+	short := analysis.Sectors[1]
+	assert.False(t, short.AutoTerminated)
+	assert.Equal(t, big.Mul(result.SectorResults[2].FaultFee, big.NewInt(11)), short.FaultPenalty)
+	assert.True(t, short.RewardsForgone.LessThan(long.RewardsForgone))
+
+	assert.Equal(t, big.Add(long.KeepNet, short.KeepNet), analysis.KeepNet)
+	assert.Equal(t, result.TotalFee, analysis.Fee)
+}
+
+func TestSnapshotFindBestTerminationEpoch(t *testing.T) {
+	snap := testSnapshot()
+
+	// Held proven, sector 1 earns rewards until it expires at 1,500,000 without a fee
+	opt, err := snap.FindBestTerminationEpoch(600_000, builtin.EpochsInDay, FrozenModel{}, HoldProven)
+	require.NoError(t, err)
+	assert.Equal(t, abi.ChainEpoch(1_000_000), opt.Today.TargetEpoch)
+	assert.True(t, opt.Today.TotalFee.GreaterThan(big.Zero()))
+	assert.Equal(t, abi.ChainEpoch(1_500_000), opt.Best.TargetEpoch)
+	assert.True(t, opt.Best.TotalFee.IsZero())
+	rewards := expectedRewards(snap.rewardSmoothed, snap.powerSmoothed, opt.Today.SectorResults[0].QAPower, 500_000)
+	// Summed a step at a time, every step rounds down once
+	diff := big.Add(rewards, opt.Holding)
+	assert.True(t, diff.GreaterThanEqual(big.Zero()) && diff.LessThan(big.NewInt(500_000/builtin.EpochsInDay+1)), diff)
+	assert.Equal(t, opt.Holding, opt.BestCost)
+	assert.Greater(t, opt.Evaluated, 600_000/builtin.EpochsInDay)
+
+	// A projected reward decline lowers the rewards earned while holding
+	projected, err := snap.FindBestTerminationEpoch(600_000, builtin.EpochsInDay, CompoundModel{RewardDecayRate: 1e-6}, HoldProven)
+	require.NoError(t, err)
+	assert.True(t, projected.Holding.GreaterThan(opt.Holding))
+
+	// Expirations between the coarse steps are found as well
+	snap.sectors[0].Expiration = 1_234_567
+	opt, err = snap.FindBestTerminationEpoch(600_000, 100_000, FrozenModel{}, HoldProven)
+	require.NoError(t, err)
+	assert.Equal(t, abi.ChainEpoch(1_234_567), opt.Best.TargetEpoch)
+
+	// Only the last expiration within a step is a candidate, many sectors do not add epochs
+	snap = testSnapshot()
+	for i := range 1000 {
+		sector := *snap.sectors[0]
+		sector.SectorNumber = abi.SectorNumber(10 + i)
+		sector.Expiration = 1_000_001 + abi.ChainEpoch(i)*500
+		snap.sectors = append(snap.sectors, &sector)
+	}
+	opt, err = snap.FindBestTerminationEpoch(600_000, 100_000, FrozenModel{}, HoldProven)
+	require.NoError(t, err)
+	assert.Less(t, opt.Evaluated, 200)
+
+	// Held unproven, the fault fees outweigh waiting for the expiration
+	snap = testSnapshot()
+	opt, err = snap.FindBestTerminationEpoch(600_000, builtin.EpochsInDay, FrozenModel{}, HoldUnproven)
+	require.NoError(t, err)
+	assert.Equal(t, opt.Today.TargetEpoch, opt.Best.TargetEpoch)
+	assert.Equal(t, opt.Today.TotalFee, opt.BestCost)
+	assert.True(t, opt.Holding.IsZero())
+
+	_, err = snap.FindBestTerminationEpoch(100_000, 0, nil, HoldProven)
+	assert.Error(t, err)
+	_, err = snap.FindBestTerminationEpoch(100_000, builtin.EpochsInDay, nil, HoldMode("idle"))
+	assert.Error(t, err)
+}
+
+func TestSnapshotFindBestTerminationEpochInterior(t *testing.T) {
+	const day = builtin.EpochsInDay
+	// A young sector: the fee grows with its age from 33 to 140 days while the projected
+	// rewards decline, the cost is lowest once a day of rewards drops below a day of fee
+	// growth, about 80 days out
+	snap := testSnapshot()
+	qaPower := big.NewInt(32 << 30)
+	rewardPerEpoch := big.Div(big.Mul(snap.rewardSmoothed.PositionEstimate, qaPower), snap.powerSmoothed.PositionEstimate)
+	feeGrowth := 0.085 / float64(140*day)
+	pledge := scaleInt(rewardPerEpoch, 1/(2*feeGrowth))
+	snap.sectors = []*miner.SectorOnChainInfo{{
+		SectorNumber:   1,
+		Activation:     snap.epoch,
+		PowerBaseEpoch: snap.epoch,
+		Expiration:     snap.epoch + 1000*day,
+		InitialPledge:  pledge,
+	}}
+	model := CompoundModel{RewardDecayRate: math.Ln2 / float64(80*day)}
+
+	opt, err := snap.FindBestTerminationEpoch(120*day, day, model, HoldProven)
+	require.NoError(t, err)
+	best := opt.Best.TargetEpoch
+	assert.InDelta(t, float64(snap.epoch+80*day), float64(best), float64(day))
+	assert.True(t, opt.BestCost.LessThan(opt.Today.TotalFee))
+
+	holding, err := snap.newHoldingCosts(opt.Today, opt.End, day, model, HoldProven)
+	require.NoError(t, err)
+	for _, epoch := range []abi.ChainEpoch{snap.epoch + 40*day, best - day, best + day, opt.End} {
+		result, err := snap.Evaluate(epoch, model)
+		require.NoError(t, err)
+		held, err := holding.at(epoch)
+		require.NoError(t, err)
+		assert.True(t, big.Add(result.TotalFee, held).GreaterThanEqual(opt.BestCost), "epoch %d", epoch)
+	}
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// SectorStatus is the state of a sector in its partition at the snapshot epoch
+type SectorStatus string
+
+const (
+	SectorActive     SectorStatus = "active"
+	SectorFaulty     SectorStatus = "faulty"     // detected or declared faulty
+	SectorRecovering SectorStatus = "recovering" // faulty, declared to recover at the next window PoSt
+	SectorUnproven   SectorStatus = "unproven"   // not proven in a window PoSt yet
+	SectorTerminated SectorStatus = "terminated" // terminated early but still listed, excluded from results
+	SectorUnknown    SectorStatus = "unknown"    // not found in any partition
+)
+
+// SectorStatuses returns the statuses a SectorResult can have, in report order
+func SectorStatuses() []SectorStatus {
+	return []SectorStatus{SectorActive, SectorFaulty, SectorRecovering, SectorUnproven, SectorUnknown}
+}
+
+// StatusTotal sums the non-expired sectors of a calculation with the same status
+type StatusTotal struct {
+	Status        SectorStatus
+	Sectors       int
+	Fee           big.Int
+	InitialPledge big.Int
+}
+
+// PartitionTotal sums the non-expired sectors of a calculation in the same partition.
+// Mutable reports whether the deadline accepted terminations at the snapshot epoch.
+type PartitionTotal struct {
+	Deadline      uint64
+	Partition     uint64
+	Mutable       bool
+	Sectors       int
+	Fee           big.Int
+	InitialPledge big.Int
+}
+
+// PartitionFilter restricts a calculation to the sectors of a deadline, or of one of its
+// partitions
+type PartitionFilter struct {
+	Deadline  uint64
+	Partition *uint64 // nil means all partitions of the deadline
+}
+
+// Matches reports whether a sector in the given deadline and partition passes the filter
+func (f PartitionFilter) Matches(deadline, partition uint64) bool {
+	return deadline == f.Deadline && (f.Partition == nil || *f.Partition == partition)
+}
+
+// sectorLocation is where a sector is assigned and its status in that partition
+type sectorLocation struct {
+	deadline  uint64
+	partition uint64
+	status    SectorStatus
+}
+
+// loadSectorLocations reads the partitions of the given sectors from the deadlines of the
+// miner, along with the start of its proving period
+func loadSectorLocations(adtStore adt.Store, minerAct *types.Actor, sectors []*miner.SectorOnChainInfo) (map[abi.SectorNumber]sectorLocation, abi.ChainEpoch, error) {
+	mas, err := miner.Load(adtStore, minerAct)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load miner state: %w", err)
+	}
+
+	periodStart, err := mas.GetProvingPeriodStart()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get proving period start: %w", err)
+	}
+
+	wanted := make(map[abi.SectorNumber]bool, len(sectors))
+	for _, s := range sectors {
+		wanted[s.SectorNumber] = true
+	}
+
+	locations := make(map[abi.SectorNumber]sectorLocation, len(sectors))
+	err = mas.ForEachDeadline(func(dlIdx uint64, dl miner.Deadline) error {
+		return dl.ForEachPartition(func(partIdx uint64, part miner.Partition) error {
+			all, err := part.AllSectors()
+			if err != nil {
+				return err
+			}
+			live, err := part.LiveSectors()
+			if err != nil {
+				return err
+			}
+			faulty, err := part.FaultySectors()
+			if err != nil {
+				return err
+			}
+			recovering, err := part.RecoveringSectors()
+			if err != nil {
+				return err
+			}
+			unproven, err := part.UnprovenSectors()
+			if err != nil {
+				return err
+			}
+
+			err = all.ForEach(func(num uint64) error {
+				if !wanted[abi.SectorNumber(num)] {
+					return nil
+				}
+				s, err := partitionStatus(num, live, faulty, recovering, unproven)
+				if err != nil {
+					return err
+				}
+				locations[abi.SectorNumber(num)] = sectorLocation{deadline: dlIdx, partition: partIdx, status: s}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("deadline %d partition %d: %w", dlIdx, partIdx, err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load partitions: %w", err)
+	}
+	return locations, periodStart, nil
+}
+
+// deadlineIsMutable reports whether sectors of a deadline can be terminated at epoch, i.e.
+// epoch is at least one challenge window before the next opening of the deadline. Same
+// check as the miner actor.
+func deadlineIsMutable(periodStart abi.ChainEpoch, dlIdx uint64, epoch abi.ChainEpoch) bool {
+	info := dline.NewInfo(periodStart, dlIdx, epoch, miner.WPoStPeriodDeadlines, miner.WPoStProvingPeriod(),
+		miner.WPoStChallengeWindow(), miner.WPoStChallengeLookback, miner.FaultDeclarationCutoff).NextNotElapsed()
+	return epoch < info.Open-miner.WPoStChallengeWindow()
+}
+
+// partitionStatus classifies a sector of a partition, recoveries are a subset of faults
+func partitionStatus(num uint64, live, faulty, recovering, unproven bitfield.BitField) (SectorStatus, error) {
+	for _, check := range []struct {
+		set    bitfield.BitField
+		want   bool
+		status SectorStatus
+	}{
+		{live, false, SectorTerminated},
+		{recovering, true, SectorRecovering},
+		{faulty, true, SectorFaulty},
+		{unproven, true, SectorUnproven},
+	} {
+		isSet, err := check.set.IsSet(num)
+		if err != nil {
+			return "", err
+		}
+		if isSet == check.want {
+			return check.status, nil
+		}
+	}
+	return SectorActive, nil
+}
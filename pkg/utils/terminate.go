@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	stactorsminer "github.com/filecoin-project/go-state-types/builtin/v16/miner"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+)
+
+// TerminationLimits caps a single TerminateSectors message
+type TerminationLimits struct {
+	MaxPartitions int // declarations, one per partition
+	MaxSectors    int
+}
+
+// TerminationLimitsFor returns the protocol limits of a TerminateSectors message at network version nv
+func TerminationLimitsFor(nv network.Version) (TerminationLimits, error) {
+	partitions, err := policy.GetDeclarationsMax(nv)
+	if err != nil {
+		return TerminationLimits{}, fmt.Errorf("%w: %d", ErrUnsupportedNetwork, nv)
+	}
+	sectors, err := policy.GetAddressedSectorsMax(nv)
+	if err != nil {
+		return TerminationLimits{}, fmt.Errorf("%w: %d", ErrUnsupportedNetwork, nv)
+	}
+	return TerminationLimits{MaxPartitions: partitions, MaxSectors: sectors}, nil
+}
+
+// TerminationBatch is the content of one TerminateSectors message. The actor rejects a
+// message if any of its deadlines is immutable, so mutable and immutable deadlines are
+// never mixed.
+type TerminationBatch struct {
+	Declarations  []stactorsminer.TerminationDeclaration
+	Mutable       bool
+	Sectors       int
+	Fee           big.Int // estimated termination fee of the sectors
+	InitialPledge big.Int
+}
+
+// Params returns the TerminateSectors parameters of the batch
+func (b TerminationBatch) Params() *stactorsminer.TerminateSectorsParams {
+	return &stactorsminer.TerminateSectorsParams{Terminations: b.Declarations}
+}
+
+// PlanTerminations groups the sectors of a calculation into TerminateSectors messages within
+// limits, by deadline and partition with mutable deadlines first. Expired sectors and
+// sectors not found in any partition cannot be terminated and are returned as skipped.
+func PlanTerminations(result CalculationResult, limits TerminationLimits) ([]TerminationBatch, []abi.SectorNumber, error) {
+	if limits.MaxPartitions <= 0 || limits.MaxSectors <= 0 {
+		return nil, nil, fmt.Errorf("invalid termination limits: %d partitions, %d sectors", limits.MaxPartitions, limits.MaxSectors)
+	}
+
+	mutable := make(map[[2]uint64]bool, len(result.PartitionTotals))
+	for _, pt := range result.PartitionTotals {
+		mutable[[2]uint64{pt.Deadline, pt.Partition}] = pt.Mutable
+	}
+
+	var skipped []abi.SectorNumber
+	var keys [][2]uint64
+	sectors := make(map[[2]uint64][]SectorResult)
+	for _, s := range result.SectorResults {
+		if s.IsExpired || s.Status == SectorUnknown {
+			skipped = append(skipped, s.SectorNumber)
+			continue
+		}
+		key := [2]uint64{s.Deadline, s.Partition}
+		if _, ok := sectors[key]; !ok {
+			keys = append(keys, key)
+		}
+		sectors[key] = append(sectors[key], s)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if mutable[keys[i]] != mutable[keys[j]] {
+			return mutable[keys[i]]
+		}
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	var batches []TerminationBatch
+	for _, key := range keys {
+		remaining := sectors[key]
+		sort.Slice(remaining, func(i, j int) bool { return remaining[i].SectorNumber < remaining[j].SectorNumber })
+
+		for len(remaining) > 0 {
+			last := len(batches) - 1
+			if last < 0 || batches[last].Mutable != mutable[key] || batches[last].Sectors == limits.MaxSectors || len(batches[last].Declarations) == limits.MaxPartitions {
+				batches = append(batches, TerminationBatch{Mutable: mutable[key], Fee: big.Zero(), InitialPledge: big.Zero()})
+				last++
+			}
+			cur := &batches[last]
+
+			n := min(len(remaining), limits.MaxSectors-cur.Sectors)
+			nums := make([]uint64, 0, n)
+			for _, s := range remaining[:n] {
+				nums = append(nums, uint64(s.SectorNumber))
+				cur.Fee = big.Add(cur.Fee, s.Fee)
+				cur.InitialPledge = big.Add(cur.InitialPledge, s.InitialPledge)
+			}
+			cur.Declarations = append(cur.Declarations, stactorsminer.TerminationDeclaration{
+				Deadline:  key[0],
+				Partition: key[1],
+				Sectors:   bitfield.NewFromSet(nums),
+			})
+			cur.Sectors += n
+			remaining = remaining[n:]
+		}
+	}
+	return batches, skipped, nil
+}
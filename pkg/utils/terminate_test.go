@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminationLimitsFor(t *testing.T) {
+	limits, err := TerminationLimitsFor(network.Version25)
+	require.NoError(t, err)
+	assert.Equal(t, TerminationLimits{MaxPartitions: 3000, MaxSectors: 25_000}, limits)
+
+	_, err = TerminationLimitsFor(network.Version(99))
+	assert.ErrorIs(t, err, ErrUnsupportedNetwork)
+}
+
+func TestPlanTerminations(t *testing.T) {
+	sector := func(num abi.SectorNumber, dl, part uint64) SectorResult {
+		return SectorResult{SectorNumber: num, Status: SectorActive, Deadline: dl, Partition: part, Fee: big.NewInt(int64(num)), InitialPledge: big.NewInt(10)}
+	}
+	result := CalculationResult{
+		SectorResults: []SectorResult{
+			sector(7, 3, 0),
+			sector(1, 1, 0),
+			sector(2, 1, 0),
+			sector(3, 1, 0),
+			sector(4, 1, 1),
+			sector(5, 2, 0),
+			{SectorNumber: 6, Status: SectorActive, IsExpired: true},
+			{SectorNumber: 8, Status: SectorUnknown},
+		},
+		PartitionTotals: []PartitionTotal{
+			{Deadline: 1, Partition: 0, Mutable: true},
+			{Deadline: 1, Partition: 1, Mutable: true},
+			{Deadline: 2, Partition: 0, Mutable: true},
+			{Deadline: 3, Partition: 0, Mutable: false},
+		},
+	}
+
+	batches, skipped, err := PlanTerminations(result, TerminationLimits{MaxPartitions: 2, MaxSectors: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []abi.SectorNumber{6, 8}, skipped)
+
+	type decl struct {
+		dl, part uint64
+		sectors  []uint64
+	}
+	var got [][]decl
+	for _, b := range batches {
+		var decls []decl
+		for _, d := range b.Declarations {
+			nums, err := d.Sectors.All(100)
+			require.NoError(t, err)
+			decls = append(decls, decl{d.Deadline, d.Partition, nums})
+		}
+		got = append(got, decls)
+	}
+
+	// Partition 1/0 is split at the sector limit, the immutable deadline 3 comes last on its own
+	assert.Equal(t, [][]decl{
+		{{1, 0, []uint64{1, 2}}},
+		{{1, 0, []uint64{3}}, {1, 1, []uint64{4}}},
+		{{2, 0, []uint64{5}}},
+		{{3, 0, []uint64{7}}},
+	}, got)
+	assert.Equal(t, []bool{true, true, true, false}, []bool{batches[0].Mutable, batches[1].Mutable, batches[2].Mutable, batches[3].Mutable})
+	assert.Equal(t, big.NewInt(7), batches[1].Fee)
+	assert.Equal(t, 2, batches[1].Sectors)
+	assert.Equal(t, big.NewInt(20), batches[1].InitialPledge)
+
+	_, _, err = PlanTerminations(result, TerminationLimits{})
+	assert.Error(t, err)
+}
@@ -7,13 +7,20 @@ import (
 	"time"
 
 	"github.com/filecoin-project/go-state-types/abi"
-	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
 )
 
 // EpochDuration is the duration of each epoch (30 seconds)
 const EpochDuration = 30 * time.Second
 
+// Default estimation parameters based on reasonable assumptions
+const (
+	DefaultPowerGrowthRate = 0.0001  // ~0.01% growth per epoch
+	DefaultRewardDecayRate = 0.00005 // ~0.005% decay per epoch
+	DefaultMinRewardFactor = 0.1     // reward never drops below 10%
+)
+
 // ParseSectorNumbers parses sector number string and returns slice of sector numbers
 func ParseSectorNumbers(sectorsStr string) ([]abi.SectorNumber, error) {
 	parts := strings.Split(sectorsStr, ",")
@@ -65,43 +72,9 @@ func ParseSectorNumbers(sectorsStr string) ([]abi.SectorNumber, error) {
 	return sectorNumbers, nil
 }
 
-// AdjustNetworkParams adjusts network parameters for future estimation
+// AdjustNetworkParams adjusts network parameters for future estimation using the default model
 func AdjustNetworkParams(reward, power builtin.FilterEstimate, projectionEpochs abi.ChainEpoch) (builtin.FilterEstimate, builtin.FilterEstimate) {
-	if projectionEpochs <= 0 {
-		return reward, power
-	}
-
-	// Default estimation parameters based on reasonable assumptions
-	powerGrowthRate := 0.0001  // ~0.01% growth per epoch
-	rewardDecayRate := 0.00005 // ~0.005% decay per epoch
-
-	// Apply growth rates
-	growthFactor := 1.0 + powerGrowthRate*float64(projectionEpochs)
-	decayFactor := 1.0 - rewardDecayRate*float64(projectionEpochs)
-
-	if decayFactor < 0.1 {
-		decayFactor = 0.1 // minimum 10%
-	}
-
-	// Adjust power
-	newPowerPos := big.Mul(power.PositionEstimate, big.NewInt(int64(growthFactor*1000)))
-	newPowerPos = big.Div(newPowerPos, big.NewInt(1000))
-	newPowerVel := big.Mul(power.VelocityEstimate, big.NewInt(int64(growthFactor*1000)))
-	newPowerVel = big.Div(newPowerVel, big.NewInt(1000))
-
-	// Adjust reward
-	newRewardPos := big.Mul(reward.PositionEstimate, big.NewInt(int64(decayFactor*1000)))
-	newRewardPos = big.Div(newRewardPos, big.NewInt(1000))
-	newRewardVel := big.Mul(reward.VelocityEstimate, big.NewInt(int64(decayFactor*1000)))
-	newRewardVel = big.Div(newRewardVel, big.NewInt(1000))
-
-	return builtin.FilterEstimate{
-			PositionEstimate: newRewardPos,
-			VelocityEstimate: newRewardVel,
-		}, builtin.FilterEstimate{
-			PositionEstimate: newPowerPos,
-			VelocityEstimate: newPowerVel,
-		}
+	return DefaultNetworkModel().Project(reward, power, projectionEpochs)
 }
 
 // EpochsToDays converts epochs to days (2880 epochs = 1 day)
@@ -128,6 +101,12 @@ func TimeToEpoch(t time.Time, genesisTime time.Time) abi.ChainEpoch {
 	return abi.ChainEpoch(duration / EpochDuration)
 }
 
+// GenesisTime derives the genesis time from any tipset, block timestamps advance by exactly
+// one EpochDuration per epoch including null rounds
+func GenesisTime(ts *types.TipSet) time.Time {
+	return time.Unix(int64(ts.MinTimestamp()), 0).Add(-time.Duration(ts.Height()) * EpochDuration)
+}
+
 // ParseTime parses time string in various formats
 // Times without timezone information are parsed as local time
 func ParseTime(timeStr string) (time.Time, error) {
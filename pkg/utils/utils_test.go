@@ -270,6 +270,20 @@ func TestAdjustNetworkParamsMinimumReward(t *testing.T) {
 		"Reward should not go below 10% of original value")
 }
 
+func TestProjectionInfoString(t *testing.T) {
+	p := ProjectionInfo{
+		Model:  "linear",
+		Epochs: 2880,
+		Params: map[string]float64{
+			"reward_decay_rate": DefaultRewardDecayRate,
+			"power_growth_rate": DefaultPowerGrowthRate,
+		},
+	}
+	assert.Equal(t, "linear(power_growth_rate=0.0001, reward_decay_rate=5e-05)", p.String())
+
+	assert.Equal(t, "linear()", ProjectionInfo{Model: "linear"}.String())
+}
+
 func TestEpochsToDays(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -472,6 +486,14 @@ func TestTimeToEpoch(t *testing.T) {
 	}
 }
 
+func TestGenesisTimeFromTipSet(t *testing.T) {
+	// Fake tipsets are stamped height*30 seconds after the unix epoch
+	ts := fakeTipSetAt(t, 1_000_000, nil, must(abi.CidBuilder.Sum([]byte("state"))))
+	genesis := GenesisTime(ts)
+	assert.True(t, genesis.Equal(time.Unix(0, 0)), "got %s", genesis)
+	assert.Equal(t, ts.Height(), TimeToEpoch(time.Unix(int64(ts.MinTimestamp()), 0), genesis))
+}
+
 func TestParseTime(t *testing.T) {
 	tests := []struct {
 		name     string